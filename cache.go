@@ -1,42 +1,103 @@
 package notstd
 
 import (
+	"context"
 	"errors"
+	"math"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrNotFound reports that a key has no value, e.g. when a bulk defaultFn omits a requested key
+// defaultFn can return it (possibly wrapped) to have the miss cached, see Cache.WithNegativeCache
+var ErrNotFound = errors.New("not found")
+
+// CacheDefaultTTL can be passed as a per-entry TTL to use the timeout the cache was created with
+// Any other negative TTL means the value is already expired, e.g. a token past its expiry
+const CacheDefaultTTL time.Duration = math.MinInt64
+
 // CacheValue is a thread-safe cache for a single value with optional timeout and default function
 type CacheValue[T any] struct {
 	mu        sync.RWMutex
 	value     T
 	expiresAt time.Time
-	defaultFn func() (T, error)
+	setAt     time.Time
+	ttl       time.Duration
+	defaultFn func() (T, time.Duration, error)
 	timeout   time.Duration
 	hasValue  bool
+
+	// Cached defaultFn error, valid until expiresAt (see Cache.WithNegativeCache)
+	err error
+
+	clock Clock
+	stats cacheCounters
+
+	// In-flight defaultFn call shared by concurrent loaders
+	loadMu  sync.Mutex
+	loading ResultWaiterFn[T]
 }
 
 // NewCacheValue creates a new CacheValue instance
 // timeout: cache entry expiration time (0 = no expiration)
 // defaultFn: function to generate value if not found (nil = no default)
 func NewCacheValue[T any](timeout time.Duration, defaultFn func() (T, error)) *CacheValue[T] {
-	return &CacheValue[T]{
-		timeout:   timeout,
-		defaultFn: defaultFn,
+	cv := &CacheValue[T]{
+		timeout: timeout,
+		clock:   RealClock{},
 	}
+	if defaultFn != nil {
+		cv.defaultFn = func() (T, time.Duration, error) {
+			val, err := defaultFn()
+			return val, CacheDefaultTTL, err
+		}
+	}
+	return cv
+}
+
+// WithTTLDefaultFn replaces defaultFn with a function that also returns the TTL of the value
+// (CacheDefaultTTL = use timeout, 0 = no expiration, negative = already expired)
+// Must be called before the CacheValue is used
+func (cv *CacheValue[T]) WithTTLDefaultFn(defaultFn func() (T, time.Duration, error)) *CacheValue[T] {
+	cv.defaultFn = defaultFn
+	return cv
+}
+
+// WithClock sets the clock used for expiration (default RealClock)
+// Must be called before the CacheValue is used
+func (cv *CacheValue[T]) WithClock(clock Clock) *CacheValue[T] {
+	cv.clock = clock
+	return cv
 }
 
 // isExpired checks if the value has expired
 func (cv *CacheValue[T]) isExpired() bool {
-	if cv.timeout == 0 || !cv.hasValue {
+	if !cv.hasValue || cv.expiresAt.IsZero() {
 		return false
 	}
-	return time.Now().After(cv.expiresAt)
+	return cv.clock.Now().After(cv.expiresAt)
+}
+
+// entry returns the stored value regardless of expiry, its expiration time
+// (zero = never expires) and whether a value is stored at all
+func (cv *CacheValue[T]) entry() (T, time.Time, bool) {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+	return cv.value, cv.expiresAt, cv.hasValue
 }
 
 // GetNoDefault retrieves the value without using defaultFn
 // Returns (value, true) if found and not expired, (zero, false) otherwise
 func (cv *CacheValue[T]) GetNoDefault() (T, bool) {
+	val, ok := cv.get()
+	cv.stats.lookup(ok)
+	return val, ok
+}
+
+// get is GetNoDefault without updating stats
+func (cv *CacheValue[T]) get() (T, bool) {
 	cv.mu.RLock()
 	defer cv.mu.RUnlock()
 
@@ -66,14 +127,12 @@ func (cv *CacheValue[T]) GetDefault() (T, bool, error) {
 	}
 
 	// Call defaultFn without holding the lock
-	val, err := cv.defaultFn()
+	val, err := cv.load()
 	if err != nil {
 		var zero T
 		return zero, false, err
 	}
 
-	// Store the value
-	cv.Set(val)
 	return val, false, nil
 }
 
@@ -96,18 +155,63 @@ func (cv *CacheValue[T]) Get() (T, bool, error) {
 // Set stores a value in the cache
 // Returns true if an actual (non-expired) value was overwritten
 func (cv *CacheValue[T]) Set(value T) bool {
+	return cv.SetWithTTL(value, CacheDefaultTTL)
+}
+
+// SetWithTTL stores a value in the cache with its own expiration time
+// ttl: CacheDefaultTTL = use timeout, 0 = no expiration, negative = already expired
+// Returns true if an actual (non-expired) value was overwritten
+func (cv *CacheValue[T]) SetWithTTL(value T, ttl time.Duration) bool {
+	_, _, wasActual := cv.set(value, ttl)
+	return wasActual
+}
+
+// set stores a value and returns the previous one, whether there was one and whether it was actual
+func (cv *CacheValue[T]) set(value T, ttl time.Duration) (old T, hadValue, wasActual bool) {
 	cv.mu.Lock()
 	defer cv.mu.Unlock()
 
-	wasActual := cv.hasValue && !cv.isExpired()
+	old, hadValue = cv.value, cv.hasValue
+	wasActual = cv.hasValue && !cv.isExpired()
 
+	if ttl == CacheDefaultTTL {
+		ttl = cv.timeout
+	}
+
+	now := cv.clock.Now()
 	cv.value = value
 	cv.hasValue = true
-	if cv.timeout > 0 {
-		cv.expiresAt = time.Now().Add(cv.timeout)
+	cv.err = nil
+	cv.setAt = now
+	cv.ttl = ttl
+	cv.expiresAt = time.Time{}
+	if ttl != 0 {
+		// A negative TTL puts the expiry in the past
+		cv.expiresAt = now.Add(ttl)
 	}
 
-	return wasActual
+	return old, hadValue, wasActual
+}
+
+// touch extends the expiration of an actual value by its TTL from now,
+// but not beyond maxLifetime after it was set (0 = no limit)
+func (cv *CacheValue[T]) touch(maxLifetime time.Duration) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	if !cv.hasValue || cv.ttl <= 0 || cv.isExpired() {
+		return
+	}
+
+	expiresAt := cv.clock.Now().Add(cv.ttl)
+	if maxLifetime > 0 {
+		if deadline := cv.setAt.Add(maxLifetime); expiresAt.After(deadline) {
+			expiresAt = deadline
+		}
+	}
+	if expiresAt.After(cv.expiresAt) {
+		cv.expiresAt = expiresAt
+	}
 }
 
 // Delete removes the value from the cache
@@ -121,14 +225,70 @@ func (cv *CacheValue[T]) Delete() bool {
 	var zero T
 	cv.value = zero
 	cv.hasValue = false
+	cv.err = nil
 	cv.expiresAt = time.Time{}
 
 	return wasActual
 }
 
+// setError replaces the value with a cached error that expires after ttl
+// Returns the previous value, whether there was one and whether it was actual
+func (cv *CacheValue[T]) setError(err error, ttl time.Duration) (old T, hadValue, wasActual bool) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+
+	old, hadValue = cv.value, cv.hasValue
+	wasActual = cv.hasValue && !cv.isExpired()
+
+	var zero T
+	cv.value = zero
+	cv.hasValue = false
+	cv.err = err
+	cv.expiresAt = cv.clock.Now().Add(ttl)
+
+	return old, hadValue, wasActual
+}
+
+// cachedError returns the cached error if it has not expired
+func (cv *CacheValue[T]) cachedError() error {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	if cv.err == nil || cv.clock.Now().After(cv.expiresAt) {
+		return nil
+	}
+	return cv.err
+}
+
+// isStale reports whether the entry holds neither a value nor a cached error at now,
+// treating values as usable for grace past their expiry
+func (cv *CacheValue[T]) isStale(now time.Time, grace time.Duration) bool {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	switch {
+	case cv.err != nil:
+		return now.After(cv.expiresAt)
+	case !cv.hasValue:
+		return true
+	case cv.expiresAt.IsZero():
+		return false
+	default:
+		return now.After(cv.expiresAt.Add(grace))
+	}
+}
+
+// servable reports whether cv holds a value that can be served at now, including a value expired less than grace ago
+func (cv *CacheValue[T]) servable(now time.Time, grace time.Duration) bool {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+
+	return cv.hasValue && (cv.expiresAt.IsZero() || !now.After(cv.expiresAt.Add(grace)))
+}
+
 // Has checks if a value exists and is not expired
 func (cv *CacheValue[T]) Has() bool {
-	_, ok := cv.GetNoDefault()
+	_, ok := cv.get()
 	return ok
 }
 
@@ -145,22 +305,113 @@ func (cv *CacheValue[T]) Update() error {
 	}
 
 	// Call defaultFn without holding the lock
-	val, err := cv.defaultFn()
-	if err != nil {
-		return err
+	_, err := cv.load()
+	return err
+}
+
+// load calls defaultFn and stores its result
+// Concurrent callers share a single in-flight call and receive the same value or error
+func (cv *CacheValue[T]) load() (T, error) {
+	cv.loadMu.Lock()
+	waiter := cv.loading
+	if waiter == nil {
+		waiter = WaitResult(func() (T, error) {
+			started := cv.clock.Now()
+			val, ttl, err := cv.defaultFn()
+			cv.stats.load(cv.clock.Now().Sub(started), err)
+			if err == nil {
+				cv.SetWithTTL(val, ttl)
+			}
+
+			// Callers arriving after this point start a fresh load
+			cv.loadMu.Lock()
+			cv.loading = nil
+			cv.loadMu.Unlock()
+			return val, err
+		})
+		cv.loading = waiter
 	}
+	cv.loadMu.Unlock()
 
-	cv.Set(val)
-	return nil
+	res, _ := waiter(context.Background())
+	return res.Result, res.Error
 }
 
+// CacheLoaderFn is a context-aware defaultFn for Cache
+// ctx carries the values of the caller that started the load but not its deadline;
+// since the load is shared, ctx is cancelled only once the last waiting caller gives up
+type CacheLoaderFn[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
 // Cache is a generic thread-safe in-memory cache service
 type Cache[K comparable, V any] struct {
 	mu        sync.RWMutex
 	storage   map[K]*CacheValue[V]
 	keyFn     KeyFn[V, K]
-	defaultFn func(K) (V, error)
+	defaultFn func(context.Context, K) (V, time.Duration, error)
+	bulkFn    func([]K) (map[K]V, error)
 	timeout   time.Duration
+
+	// Eviction, enabled by WithMaxEntries and WithEvictionPolicy
+	maxEntries int
+	policy     EvictionPolicy[K]
+
+	// Cost-weighted eviction, see WithMaxCost
+	maxCost   int64
+	costFn    func(key K, value V) int64
+	costs     map[K]int64
+	totalCost int64
+
+	// In-flight defaultFn calls shared by concurrent loaders of the same key
+	loads *Store[K, *cacheLoad[V]]
+
+	// Caching of defaultFn errors, see WithNegativeCache
+	negativeTTL   time.Duration
+	negativeMatch FilterFn[error]
+
+	// Expiration extended on reads, see WithSlidingExpiration
+	sliding     bool
+	maxLifetime time.Duration
+
+	// Background reloads, see WithStaleWhileRevalidate and WithRefreshAhead
+	maxStale     time.Duration
+	refreshAhead time.Duration
+
+	clock Clock
+	stats cacheCounters
+
+	// Evict notifications, see WithEvictHandler
+	onEvict func(key K, value V, reason EvictReason)
+	evicted []cacheEviction[K, V]
+
+	// Change events, see Subscribe
+	subsMu        sync.RWMutex
+	subs          map[chan CacheEvent[K, V]]struct{}
+	subscribed    atomic.Bool
+	eventBuffer   int
+	droppedEvents atomic.Int64
+
+	// Group invalidation, see SetWithTags and InvalidateTag
+	tagFn    func(key K, value V) []string
+	tagIndex map[string]Set[K]
+	keyTags  map[K][]string
+	tagged   atomic.Bool
+
+	// Second cache layer, see WithSecondaryStore
+	secondary        SecondaryStore[K, V]
+	secondaryMode    SecondaryWriteMode
+	onSecondaryError func(key K, err error)
+	writesMu         sync.Mutex
+	writes           map[K]secondaryWrite[V]
+	flushing         bool
+	flushed          chan struct{}
+
+	// Persistence format, see Snapshot and Restore
+	codec CacheCodec
+
+	// Background purging of expired entries, see StartJanitor
+	janitorMu     sync.Mutex
+	janitorCancel context.CancelFunc
+	janitorWg     sync.WaitGroup
 }
 
 // NewCache creates a new Cache instance
@@ -179,91 +430,435 @@ func NewCache[K comparable, V any](
 		storage = make(map[K]*CacheValue[V], capacity)
 	}
 
-	return &Cache[K, V]{
-		storage:   storage,
-		timeout:   timeout,
-		keyFn:     keyFn,
-		defaultFn: defaultFn,
+	c := &Cache[K, V]{
+		storage: storage,
+		timeout: timeout,
+		keyFn:   keyFn,
+		loads:   NewStore[K, *cacheLoad[V]](nil),
+		clock:   RealClock{},
 	}
+	if defaultFn != nil {
+		c.defaultFn = func(_ context.Context, key K) (V, time.Duration, error) {
+			val, err := defaultFn(key)
+			return val, CacheDefaultTTL, err
+		}
+	}
+	return c
 }
 
-// getCacheValue retrieves or creates a CacheValue for a key
-func (c *Cache[K, V]) getCacheValue(key K) *CacheValue[V] {
-	c.mu.RLock()
+// WithLoader replaces defaultFn with a context-aware loader, see GetCtx and GetDefaultCtx
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithLoader(loader CacheLoaderFn[K, V]) *Cache[K, V] {
+	c.defaultFn = func(ctx context.Context, key K) (V, time.Duration, error) {
+		val, err := loader(ctx, key)
+		return val, CacheDefaultTTL, err
+	}
+	return c
+}
+
+// WithBulkDefaultFn sets the function GetMany uses to load all missing keys in one call
+// Keys absent from the returned map are reported as ErrNotFound
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithBulkDefaultFn(bulkFn func([]K) (map[K]V, error)) *Cache[K, V] {
+	c.bulkFn = bulkFn
+	return c
+}
+
+// WithTTLDefaultFn replaces defaultFn with a function that also returns the TTL of each value,
+// e.g. taken from Cache-Control or a token expiry
+// (CacheDefaultTTL = use timeout, 0 = no expiration, negative = already expired)
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithTTLDefaultFn(defaultFn func(K) (V, time.Duration, error)) *Cache[K, V] {
+	c.defaultFn = func(_ context.Context, key K) (V, time.Duration, error) {
+		return defaultFn(key)
+	}
+	return c
+}
+
+// WithClock sets the clock used for expiration and the janitor (default RealClock)
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithClock(clock Clock) *Cache[K, V] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock = clock
+	for _, cv := range c.storage {
+		cv.WithClock(clock)
+	}
+	return c
+}
+
+// WithMaxEntries bounds the cache to maxEntries keys (0 = unbounded)
+// Uses least-recently-used eviction unless WithEvictionPolicy selects another policy
+// Access is tracked on Get, GetDefault, GetNoDefault and Set
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithMaxEntries(maxEntries int) *Cache[K, V] {
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.maxEntries = maxEntries
+	if maxEntries > 0 && c.policy == nil {
+		c.setPolicyLocked(NewLRUPolicy[K]())
+	}
+	c.evictLocked(c.maxEntries)
+	return c
+}
+
+// WithEvictionPolicy sets the policy that chooses which keys to evict once the cache
+// exceeds its WithMaxEntries limit (e.g. NewLRUPolicy, NewLFUPolicy, NewFIFOPolicy, NewTinyLFUPolicy)
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithEvictionPolicy(policy EvictionPolicy[K]) *Cache[K, V] {
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.setPolicyLocked(policy)
+	c.evictLocked(c.maxEntries)
+	return c
+}
+
+// WithNegativeCache caches defaultFn errors accepted by match for ttl, so repeated requests
+// for a missing key do not reach the backend; GetDefault and Get return the cached error
+// match: which errors to cache (nil = only ErrNotFound)
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithNegativeCache(ttl time.Duration, match FilterFn[error]) *Cache[K, V] {
+	if match == nil {
+		match = func(err error) bool {
+			return errors.Is(err, ErrNotFound)
+		}
+	}
+	c.negativeTTL = ttl
+	c.negativeMatch = match
+	return c
+}
+
+// WithSlidingExpiration makes every successful read extend the entry expiry by its TTL,
+// so entries expire only after a period of inactivity
+// maxLifetime caps the total lifetime of an entry since it was set (0 = no limit)
+// Has, Len, Keys, Values and Range do not extend expiry
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithSlidingExpiration(maxLifetime time.Duration) *Cache[K, V] {
+	c.sliding = true
+	c.maxLifetime = maxLifetime
+	return c
+}
+
+// WithStaleWhileRevalidate keeps serving an expired value from GetDefault for up to
+// maxStale after its expiry while defaultFn reloads it in the background (0 = disabled)
+// Failed background reloads are ignored and the stale value keeps being served
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithStaleWhileRevalidate(maxStale time.Duration) *Cache[K, V] {
+	c.maxStale = maxStale
+	return c
+}
+
+// WithRefreshAhead makes GetDefault reload an entry in the background when it is read
+// within window of its expiry, so hot entries are refreshed before they expire (0 = disabled)
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithRefreshAhead(window time.Duration) *Cache[K, V] {
+	c.refreshAhead = window
+	return c
+}
+
+// setPolicyLocked replaces the eviction policy and registers existing keys with it
+func (c *Cache[K, V]) setPolicyLocked(policy EvictionPolicy[K]) {
+	c.policy = policy
+	if policy == nil {
+		return
+	}
+	for k := range c.storage {
+		policy.OnInsert(k)
+	}
+}
+
+// touchLocked reports an access to key to the eviction policy; caller must hold the write lock
+func (c *Cache[K, V]) touchLocked(key K) {
+	if c.policy != nil {
+		c.policy.OnAccess(key)
+	}
+}
+
+// insertLocked adds a new CacheValue for key, evicting first if the cache is full
+// Caller must hold the write lock
+func (c *Cache[K, V]) insertLocked(key K, cv *CacheValue[V]) {
+	c.evictLocked(c.maxEntries - 1)
+	c.storage[key] = cv
+	if c.policy != nil {
+		c.policy.OnInsert(key)
+	}
+}
+
+// removeLocked deletes key from storage and the eviction policy, recording the eviction
+// Caller must hold the write lock and release it with unlock
+func (c *Cache[K, V]) removeLocked(key K, reason EvictReason) {
+	cv, ok := c.detachLocked(key)
+	if !ok {
+		return
+	}
+	if reason == EvictCapacity || reason == EvictExpired {
+		c.stats.evict()
+	}
+	c.recordEvictionLocked(key, cv, reason)
+}
+
+// detachLocked deletes key from storage, the eviction policy, tags and costs without recording anything
+// Caller must hold the write lock
+func (c *Cache[K, V]) detachLocked(key K) (*CacheValue[V], bool) {
 	cv, ok := c.storage[key]
-	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	delete(c.storage, key)
+	if c.policy != nil {
+		c.policy.OnRemove(key)
+	}
+	c.untagLocked(key)
+	c.unchargeLocked(key)
+	return cv, true
+}
+
+// replaceAll atomically replaces the whole contents of the cache with items stored with ttl,
+// so readers see either the old or the new contents; it is reported like Delete of the keys
+// missing from items followed by Set of every item, and then the evictions of items
+// that did not fit the cache limits
+func (c *Cache[K, V]) replaceAll(items map[K]V, ttl time.Duration) {
+	storage := make(map[K]*CacheValue[V], len(items))
+	tags := make(map[K][]string)
+	costs := make(map[K]int64)
+	for k, v := range items {
+		cv := NewCacheValue[V](c.timeout, nil).WithClock(c.clock)
+		cv.set(v, ttl)
+		storage[k] = cv
+		if t := c.tagsOf(k, v, nil); len(t) > 0 {
+			tags[k] = t
+		}
+		if c.maxCost > 0 && c.costFn != nil {
+			costs[k] = c.costFn(k, v)
+		}
+	}
+
+	type replacement struct {
+		old                 V
+		hadValue, wasActual bool
+	}
+	replaced := make(map[K]replacement)
+	var deleted []K
 
+	c.mu.Lock()
+	for k, cv := range c.storage {
+		if _, ok := storage[k]; !ok {
+			c.removeLocked(k, EvictDeleted)
+			deleted = append(deleted, k)
+			continue
+		}
+		c.detachLocked(k)
+		old, _, hadValue := cv.entry()
+		replaced[k] = replacement{old: old, hadValue: hadValue, wasActual: cv.Has()}
+	}
+
+	c.storage = storage
+	for k := range storage {
+		if c.policy != nil {
+			c.policy.OnInsert(k)
+		}
+		c.tagLocked(k, tags[k])
+	}
+
+	// Items evicted to fit the limits are reported after their Set
+	removed := len(c.evicted)
+	c.evictLocked(c.maxEntries)
+	for k, cost := range costs {
+		if _, ok := c.storage[k]; ok {
+			c.chargeLocked(k, cost)
+		}
+	}
+	evicted := slices.Clone(c.evicted[removed:])
+	c.evicted = c.evicted[:removed]
+	c.unlock()
+
+	for _, k := range deleted {
+		c.writeSecondary(k, secondaryWrite[V]{delete: true})
+	}
+	for k, v := range items {
+		r := replaced[k]
+		c.replaced(k, r.old, r.hadValue, r.wasActual)
+		c.publish(CacheEvent[K, V]{Type: CacheEventSet, Key: k, OldValue: r.old, HasOld: r.wasActual, NewValue: v})
+		c.writeSecondary(k, secondaryWrite[V]{value: v, ttl: ttl})
+	}
+	c.notifyEvictions(evicted)
+}
+
+// evictLocked removes policy victims until the cache holds at most limit entries
+// Does nothing for an unbounded cache; caller must hold the write lock
+func (c *Cache[K, V]) evictLocked(limit int) {
+	if c.policy == nil || c.maxEntries <= 0 {
+		return
+	}
+	for len(c.storage) > limit {
+		key, ok := c.policy.Victim()
+		if !ok {
+			return
+		}
+		c.removeLocked(key, EvictCapacity)
+	}
+}
+
+// lookup returns the CacheValue for key without creating it, tracking access when bounded
+func (c *Cache[K, V]) lookup(key K) (*CacheValue[V], bool) {
+	if c.policy == nil {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		cv, ok := c.storage[key]
+		return cv, ok
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.storage[key]
 	if ok {
-		return cv
+		c.touchLocked(key)
+	}
+	return cv, ok
+}
+
+// peek returns the CacheValue for key without creating it or tracking access
+func (c *Cache[K, V]) peek(key K) (*CacheValue[V], bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cv, ok := c.storage[key]
+	return cv, ok
+}
+
+// fillCacheValue retrieves or creates the CacheValue for a key and calls fill with it
+// fill runs under the cache lock, so a new entry cannot be removed (e.g. by Purge as empty)
+// before it is filled
+func (c *Cache[K, V]) fillCacheValue(key K, fill func(cv *CacheValue[V])) {
+	if c.policy == nil {
+		c.mu.RLock()
+		cv, ok := c.storage[key]
+		if ok {
+			fill(cv)
+		}
+		c.mu.RUnlock()
+
+		if ok {
+			return
+		}
 	}
 
 	// Create new CacheValue with timeout if not exists
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	// Double-check after acquiring write lock
-	cv, ok = c.storage[key]
+	cv, ok := c.storage[key]
 	if ok {
-		return cv
+		c.touchLocked(key)
+	} else {
+		cv = NewCacheValue[V](c.timeout, nil).WithClock(c.clock)
+		c.insertLocked(key, cv)
 	}
-
-	cv = NewCacheValue[V](c.timeout, nil)
-	c.storage[key] = cv
-	return cv
+	fill(cv)
 }
 
 // GetNoDefault retrieves a value from the cache by key without using defaultFn
 // Returns (value, true) if found and not expired, (zero, false) otherwise
 func (c *Cache[K, V]) GetNoDefault(key K) (V, bool) {
-	c.mu.RLock()
-	cv, ok := c.storage[key]
-	c.mu.RUnlock()
-
+	cv, ok := c.lookup(key)
 	if !ok {
+		c.stats.miss()
 		var zero V
 		return zero, false
 	}
 
-	return cv.GetNoDefault()
+	val, ok := cv.get()
+	c.stats.lookup(ok)
+	if ok && c.sliding {
+		cv.touch(c.maxLifetime)
+	}
+	return val, ok
 }
 
 // GetDefault retrieves a value from the cache by key, or uses defaultFn if not found
 // Returns (value, true, nil) if found actual value in cache
-// Returns (value, false, nil) if defaultFn was used successfully
+// Returns (value, false, nil) if defaultFn was used successfully or a stale value was served
 // Returns (zero, false, error) if defaultFn failed
 func (c *Cache[K, V]) GetDefault(key K) (V, bool, error) {
-	cv := c.getCacheValue(key)
+	return c.GetDefaultCtx(context.Background(), key)
+}
 
-	// If no defaultFn for the cache, just use CacheValue's GetNoDefault
-	if c.defaultFn == nil {
-		val, ok := cv.GetNoDefault()
+// GetDefaultCtx is GetDefault that stops waiting for defaultFn when ctx is done
+// Returns (zero, false, ctx.Err()) if ctx is done first; the load itself keeps running for other callers
+// and the ctx passed to the loader is cancelled only once no caller is waiting for it
+func (c *Cache[K, V]) GetDefaultCtx(ctx context.Context, key K) (V, bool, error) {
+	if c.defaultFn == nil && c.secondary == nil {
+		val, ok := c.GetNoDefault(key)
 		return val, ok, nil
 	}
 
-	// Try to get from CacheValue first
-	val, ok := cv.GetNoDefault()
-	if ok {
-		return val, true, nil
+	// Try to get from the cache first, without creating an entry on a miss
+	if val, ok, hit, err := c.getCached(key); hit {
+		c.stats.hit()
+		return val, ok, err
 	}
+	c.stats.miss()
 
 	// Call defaultFn without holding the lock
-	val, err := c.defaultFn(key)
+	val, err := c.load(ctx, key, true)
 	if err != nil {
 		var zero V
 		return zero, false, err
 	}
 
-	// Store the value
-	cv.Set(val)
 	return val, false, nil
 }
 
+// getCached serves key for GetDefault from stored values, stale values and cached errors,
+// starting background reloads as configured; hit is false if defaultFn must be called
+func (c *Cache[K, V]) getCached(key K) (val V, ok, hit bool, err error) {
+	cv, found := c.lookup(key)
+	if !found {
+		return val, false, false, nil
+	}
+
+	val, expiresAt, hasValue := cv.entry()
+	if !hasValue {
+		var zero V
+		err = cv.cachedError()
+		return zero, false, err != nil, err
+	}
+
+	now := c.clock.Now()
+	switch {
+	case expiresAt.IsZero():
+		return val, true, true, nil
+	case !now.After(expiresAt):
+		if c.refreshAhead > 0 && expiresAt.Sub(now) <= c.refreshAhead {
+			c.refresh(key)
+		}
+		if c.sliding {
+			cv.touch(c.maxLifetime)
+		}
+		return val, true, true, nil
+	case c.maxStale > 0 && !now.After(expiresAt.Add(c.maxStale)):
+		c.refresh(key)
+		return val, false, true, nil
+	}
+
+	var zero V
+	return zero, false, false, nil
+}
+
 // Get is a helper that calls GetDefault if defaultFn is set, otherwise GetNoDefault
 // Returns (value, ok, nil) if found in cache or defaultFn was used successfully
 // Returns (zero, false, error) if defaultFn failed
 func (c *Cache[K, V]) Get(key K) (V, bool, error) {
-	if c.defaultFn != nil {
-		val, ok, err := c.GetDefault(key)
+	return c.GetCtx(context.Background(), key)
+}
+
+// GetCtx is Get that stops waiting for defaultFn when ctx is done
+func (c *Cache[K, V]) GetCtx(ctx context.Context, key K) (V, bool, error) {
+	if c.defaultFn != nil || c.secondary != nil {
+		val, ok, err := c.GetDefaultCtx(ctx, key)
 		if err != nil {
 			return val, false, err
 		}
@@ -277,8 +872,30 @@ func (c *Cache[K, V]) Get(key K) (V, bool, error) {
 // Set stores a value in the cache
 // Returns true if an actual (non-expired) value was overwritten
 func (c *Cache[K, V]) Set(key K, value V) bool {
-	cv := c.getCacheValue(key)
-	return cv.Set(value)
+	return c.SetWithTTL(key, value, CacheDefaultTTL)
+}
+
+// SetWithTTL stores a value in the cache with its own expiration time
+// ttl: CacheDefaultTTL = use the cache timeout, 0 = no expiration, negative = already expired
+// Returns true if an actual (non-expired) value was overwritten
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) bool {
+	wasActual := c.set(key, value, ttl, nil)
+	c.writeSecondary(key, secondaryWrite[V]{value: value, ttl: ttl})
+	return wasActual
+}
+
+// set stores a value with its TTL and tags and reports the replaced value
+func (c *Cache[K, V]) set(key K, value V, ttl time.Duration, tags []string) bool {
+	var old V
+	var hadValue, wasActual bool
+	c.fillCacheValue(key, func(cv *CacheValue[V]) {
+		old, hadValue, wasActual = cv.set(value, ttl)
+	})
+	c.tag(key, value, tags)
+	c.charge(key, value, true)
+	c.replaced(key, old, hadValue, wasActual)
+	c.publish(CacheEvent[K, V]{Type: CacheEventSet, Key: key, OldValue: old, HasOld: wasActual, NewValue: value})
+	return wasActual
 }
 
 // SetValue stores a value in the cache using keyFn to extract the key
@@ -295,8 +912,10 @@ func (c *Cache[K, V]) SetValue(value V) bool {
 // Delete removes a value from the cache by key
 // Returns true if an actual (non-expired) value was deleted
 func (c *Cache[K, V]) Delete(key K) bool {
+	c.writeSecondary(key, secondaryWrite[V]{delete: true})
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	cv, existed := c.storage[key]
 	if !existed {
@@ -304,7 +923,7 @@ func (c *Cache[K, V]) Delete(key K) bool {
 	}
 
 	wasActual := cv.Has()
-	delete(c.storage, key)
+	c.removeLocked(key, EvictDeleted)
 	return wasActual
 }
 
@@ -318,16 +937,20 @@ func (c *Cache[K, V]) GetKeyOf(value V) K {
 }
 
 // Has checks if a key exists in the cache and is not expired
+// Has does not count as an access for eviction purposes
 func (c *Cache[K, V]) Has(key K) bool {
-	_, ok := c.GetNoDefault(key)
-	return ok
+	cv, ok := c.peek(key)
+	return ok && cv.Has()
 }
 
 // Clear removes all entries from the cache
 func (c *Cache[K, V]) Clear() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
+	for k := range c.storage {
+		c.removeLocked(k, EvictCleared)
+	}
 	c.storage = make(map[K]*CacheValue[V])
 }
 
@@ -366,7 +989,7 @@ func (c *Cache[K, V]) Values() []V {
 
 	values := make([]V, 0, len(c.storage))
 	for _, cv := range c.storage {
-		if val, ok := cv.GetNoDefault(); ok {
+		if val, ok := cv.get(); ok {
 			values = append(values, val)
 		}
 	}
@@ -380,7 +1003,7 @@ func (c *Cache[K, V]) Range(fn func(key K, value V) bool) {
 	defer c.mu.RUnlock()
 
 	for k, cv := range c.storage {
-		if val, ok := cv.GetNoDefault(); ok {
+		if val, ok := cv.get(); ok {
 			if !fn(k, val) {
 				break
 			}
@@ -400,6 +1023,91 @@ func (c *Cache[K, V]) SetMany(items map[K]V) int {
 	return count
 }
 
+// GetMany retrieves multiple values, resolving all misses through a single bulkFn call
+// Falls back to defaultFn per key if bulkFn is not set
+// Returns the resolved values and, for every key that could not be resolved, its error
+// (nil if all keys were resolved); if bulkFn fails, every missed key gets its error
+func (c *Cache[K, V]) GetMany(keys []K) (map[K]V, map[K]error) {
+	values := make(map[K]V, len(keys))
+	var errs map[K]error
+	fail := func(key K, err error) {
+		if errs == nil {
+			errs = make(map[K]error)
+		}
+		errs[key] = err
+	}
+
+	misses := make([]K, 0, len(keys))
+	seen := make(Set[K], len(keys))
+	for _, k := range keys {
+		if seen.Contains(k) {
+			continue
+		}
+		seen.Add(k)
+
+		if val, ok := c.GetNoDefault(k); ok {
+			values[k] = val
+		} else if err := c.cachedError(k); err != nil {
+			fail(k, err)
+		} else {
+			misses = append(misses, k)
+		}
+	}
+	if c.secondary != nil {
+		remote := misses
+		misses = misses[:0]
+		for _, k := range remote {
+			if val, ok := c.getSecondary(context.Background(), k); ok {
+				values[k] = val
+			} else {
+				misses = append(misses, k)
+			}
+		}
+	}
+	if len(misses) == 0 {
+		return values, errs
+	}
+
+	switch {
+	case c.bulkFn != nil:
+		started := c.clock.Now()
+		loaded, err := c.bulkFn(misses)
+		c.stats.load(c.clock.Now().Sub(started), err)
+		for _, k := range misses {
+			if err != nil {
+				fail(k, err)
+				c.cacheError(k, err)
+				continue
+			}
+			val, ok := loaded[k]
+			if !ok {
+				fail(k, ErrNotFound)
+				c.cacheError(k, ErrNotFound)
+				continue
+			}
+			c.Set(k, val)
+			values[k] = val
+		}
+
+	case c.defaultFn != nil:
+		for _, k := range misses {
+			val, err := c.load(context.Background(), k, false)
+			if err != nil {
+				fail(k, err)
+				continue
+			}
+			values[k] = val
+		}
+
+	default:
+		for _, k := range misses {
+			fail(k, ErrNotFound)
+		}
+	}
+
+	return values, errs
+}
+
 // DeleteMany removes multiple keys from the cache
 // Returns the count of actual values that were deleted
 func (c *Cache[K, V]) DeleteMany(keys []K) int {
@@ -415,16 +1123,143 @@ func (c *Cache[K, V]) DeleteMany(keys []K) int {
 // Update refreshes the cached value for a key by calling defaultFn
 // Returns error if defaultFn is not set or if defaultFn fails
 func (c *Cache[K, V]) Update(key K) error {
+	return c.UpdateCtx(context.Background(), key)
+}
+
+// UpdateCtx is Update that stops waiting for defaultFn when ctx is done
+func (c *Cache[K, V]) UpdateCtx(ctx context.Context, key K) error {
 	if c.defaultFn == nil {
 		return errors.New("defaultFn is not set")
 	}
 
 	// Call defaultFn without holding the lock
-	val, err := c.defaultFn(key)
-	if err != nil {
-		return err
+	_, err := c.load(ctx, key, false)
+	return err
+}
+
+// cacheError stores err for key if negative caching applies to it
+// A value that can still be served (e.g. a stale value being revalidated in the background) is kept
+func (c *Cache[K, V]) cacheError(key K, err error) {
+	if c.negativeTTL <= 0 || !c.negativeMatch(err) {
+		return
+	}
+	var old V
+	var hadValue, wasActual, kept bool
+	c.fillCacheValue(key, func(cv *CacheValue[V]) {
+		if kept = cv.servable(c.clock.Now(), c.maxStale); !kept {
+			old, hadValue, wasActual = cv.setError(err, c.negativeTTL)
+		}
+	})
+	if kept {
+		return
+	}
+	c.charge(key, old, false)
+	c.replaced(key, old, hadValue, wasActual)
+	if wasActual {
+		c.publish(CacheEvent[K, V]{Type: CacheEventDelete, Key: key, OldValue: old, HasOld: true})
+	}
+}
+
+// cachedError returns the unexpired cached error for key, if any
+func (c *Cache[K, V]) cachedError(key K) error {
+	cv, ok := c.peek(key)
+	if !ok {
+		return nil
 	}
+	return cv.cachedError()
+}
 
-	c.Set(key, val)
-	return nil
+// refresh reloads key in the background unless a load is already in flight
+func (c *Cache[K, V]) refresh(key K) {
+	if c.defaultFn == nil {
+		return
+	}
+	if _, ok := c.loads.Get(key); ok {
+		return
+	}
+	go c.load(context.Background(), key, false)
+}
+
+// cacheLoad is an in-flight defaultFn call shared by the callers waiting for it
+type cacheLoad[V any] struct {
+	waiter  ResultWaiterFn[V]
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// load calls defaultFn for key and stores its result
+// miss: the key is missing locally, so the secondary store is consulted before defaultFn
+// Concurrent callers for the same key share a single in-flight call and receive the same value or error
+// A caller whose ctx is done stops waiting without cancelling the shared call,
+// unless it was the last caller waiting, in which case the ctx of the call is cancelled
+// The call keeps the values of the ctx that started it, but not its cancellation or deadline
+func (c *Cache[K, V]) load(ctx context.Context, key K, miss bool) (V, error) {
+	c.loads.Lock()
+	l, ok := c.loads.GetNoLock(key)
+	if !ok {
+		loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		l = &cacheLoad[V]{cancel: cancel}
+		l.waiter = WaitResult(func() (V, error) {
+			defer cancel()
+			// Callers arriving after this load finishes start a fresh one
+			defer c.forgetLoad(key, l)
+
+			if miss {
+				if val, ok := c.getSecondary(loadCtx, key); ok {
+					return val, nil
+				}
+			}
+			if c.defaultFn == nil {
+				var zero V
+				c.cacheError(key, ErrNotFound)
+				return zero, ErrNotFound
+			}
+
+			started := c.clock.Now()
+			val, ttl, err := c.defaultFn(loadCtx, key)
+			c.stats.load(c.clock.Now().Sub(started), err)
+			switch {
+			case err == nil:
+				c.SetWithTTL(key, val, ttl)
+			case loadCtx.Err() == nil:
+				// The error of an abandoned call says nothing about the key
+				c.cacheError(key, err)
+			}
+			return val, err
+		})
+		c.loads.SetNoLock(key, l)
+	}
+	l.waiters++
+	c.loads.Unlock()
+
+	res, ok := l.waiter(ctx)
+
+	c.loads.Lock()
+	l.waiters--
+	if !ok && l.waiters == 0 {
+		// Nobody is waiting anymore: stop the call and let the next caller start a fresh one
+		l.cancel()
+		c.forgetLoadLocked(key, l)
+	}
+	c.loads.Unlock()
+
+	if !ok {
+		var zero V
+		return zero, ctx.Err()
+	}
+	return res.Result, res.Error
+}
+
+// forgetLoad removes l from the in-flight calls unless a newer call for key has replaced it
+func (c *Cache[K, V]) forgetLoad(key K, l *cacheLoad[V]) {
+	c.loads.Lock()
+	defer c.loads.Unlock()
+	c.forgetLoadLocked(key, l)
+}
+
+// forgetLoadLocked is forgetLoad for a caller holding the loads lock
+func (c *Cache[K, V]) forgetLoadLocked(key K, l *cacheLoad[V]) {
+	if cur, ok := c.loads.GetNoLock(key); ok && cur == l {
+		c.loads.DeleteNoLock(key)
+	}
 }
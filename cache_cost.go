@@ -0,0 +1,75 @@
+package notstd
+
+// WithMaxCost bounds the cache by the total cost of its values rather than their count (0 = unbounded)
+// costFn: function to compute the cost of a value, e.g. its size in bytes
+// Once a Set or a load brings the total above maxCost, policy victims are evicted until it fits,
+// so a value costing more than maxCost on its own does not stay in the cache
+// Uses least-recently-used eviction unless WithEvictionPolicy selects another policy
+// Can be combined with WithMaxEntries; must be called before the cache is used
+func (c *Cache[K, V]) WithMaxCost(maxCost int64, costFn func(key K, value V) int64) *Cache[K, V] {
+	c.mu.Lock()
+	defer c.unlock()
+
+	c.maxCost = maxCost
+	c.costFn = costFn
+	if maxCost > 0 && c.policy == nil {
+		c.setPolicyLocked(NewLRUPolicy[K]())
+	}
+	return c
+}
+
+// TotalCost returns the total cost of the values in the cache, see WithMaxCost
+func (c *Cache[K, V]) TotalCost() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.totalCost
+}
+
+// charge records the cost of the value stored for key and evicts until the cache fits maxCost
+// hasValue = false means the entry holds no value (e.g. a cached error) and costs nothing
+func (c *Cache[K, V]) charge(key K, value V, hasValue bool) {
+	if c.maxCost <= 0 || c.costFn == nil {
+		return
+	}
+
+	var cost int64
+	if hasValue {
+		cost = c.costFn(key, value)
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+
+	if _, ok := c.storage[key]; !ok {
+		c.unchargeLocked(key)
+		return
+	}
+	c.chargeLocked(key, cost)
+}
+
+// chargeLocked sets the cost of key and evicts until the cache fits maxCost
+// Caller must hold the write lock and release it with unlock
+func (c *Cache[K, V]) chargeLocked(key K, cost int64) {
+	c.unchargeLocked(key)
+	if c.costs == nil {
+		c.costs = make(map[K]int64)
+	}
+	c.costs[key] = cost
+	c.totalCost += cost
+
+	for c.policy != nil && c.totalCost > c.maxCost {
+		victim, ok := c.policy.Victim()
+		if !ok {
+			return
+		}
+		c.removeLocked(victim, EvictCapacity)
+	}
+}
+
+// unchargeLocked forgets the cost of key; caller must hold the write lock
+func (c *Cache[K, V]) unchargeLocked(key K) {
+	if cost, ok := c.costs[key]; ok {
+		c.totalCost -= cost
+		delete(c.costs, key)
+	}
+}
@@ -0,0 +1,228 @@
+package notstd
+
+import "context"
+
+// EvictReason describes why an entry left the cache
+type EvictReason int
+
+const (
+	// EvictDeleted - the entry was removed by Delete or DeleteMany
+	EvictDeleted EvictReason = iota
+	// EvictCleared - the entry was removed by Clear
+	EvictCleared
+	// EvictExpired - the entry had expired when an operation removed or replaced it
+	EvictExpired
+	// EvictCapacity - the entry was evicted to respect the cache limits
+	EvictCapacity
+	// EvictReplaced - the actual value was overwritten by Set or a reload
+	EvictReplaced
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictDeleted:
+		return "deleted"
+	case EvictCleared:
+		return "cleared"
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	case EvictReplaced:
+		return "replaced"
+	default:
+		return "unknown"
+	}
+}
+
+// cacheEviction is an eviction recorded under the cache lock and reported after it is released
+type cacheEviction[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
+}
+
+// WithEvictHandler sets a handler called whenever a value leaves the cache
+// (Delete, Clear, expiry, capacity eviction or replacement by Set), e.g. to release resources
+// The handler runs synchronously after the cache lock is released, so it may use the cache
+// Expiry is only reported when an expired value is removed or overwritten, not when it expires:
+// without StartJanitor (or calls to Purge), expired values that are never written again are not reported
+// Cached errors and empty entries are not reported
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithEvictHandler(handler func(key K, value V, reason EvictReason)) *Cache[K, V] {
+	c.onEvict = handler
+	return c
+}
+
+// recordEvictionLocked queues the value of cv for the evict handler; caller must hold the write lock
+// Expired values are always reported with EvictExpired
+func (c *Cache[K, V]) recordEvictionLocked(key K, cv *CacheValue[V], reason EvictReason) {
+	if c.onEvict == nil && !c.subscribed.Load() {
+		return
+	}
+	value, expiresAt, hasValue := cv.entry()
+	if !hasValue {
+		return
+	}
+	if !expiresAt.IsZero() && c.clock.Now().After(expiresAt) {
+		reason = EvictExpired
+	}
+	c.evicted = append(c.evicted, cacheEviction[K, V]{key: key, value: value, reason: reason})
+}
+
+// unlock releases the write lock and then reports evictions recorded while it was held
+func (c *Cache[K, V]) unlock() {
+	evicted := c.evicted
+	c.evicted = nil
+	c.mu.Unlock()
+
+	c.notifyEvictions(evicted)
+}
+
+// notifyEvictions reports recorded evictions to the evict handler and subscribers
+func (c *Cache[K, V]) notifyEvictions(evicted []cacheEviction[K, V]) {
+	for _, e := range evicted {
+		if c.onEvict != nil {
+			c.onEvict(e.key, e.value, e.reason)
+		}
+		c.publish(CacheEvent[K, V]{Type: e.reason.eventType(), Key: e.key, OldValue: e.value, HasOld: true})
+	}
+}
+
+// replaced reports a value overwritten outside the cache lock
+func (c *Cache[K, V]) replaced(key K, old V, hadValue, wasActual bool) {
+	if !hadValue {
+		return
+	}
+	if !wasActual {
+		c.publish(CacheEvent[K, V]{Type: CacheEventExpire, Key: key, OldValue: old, HasOld: true})
+	}
+	if c.onEvict == nil {
+		return
+	}
+	reason := EvictReplaced
+	if !wasActual {
+		reason = EvictExpired
+	}
+	c.onEvict(key, old, reason)
+}
+
+// CacheEventType describes how a cache entry changed
+type CacheEventType int
+
+const (
+	// CacheEventSet - a value was stored by Set or a load
+	CacheEventSet CacheEventType = iota
+	// CacheEventDelete - a value was removed by Delete, Clear or InvalidateTag,
+	// or replaced by a cached error
+	CacheEventDelete
+	// CacheEventExpire - an expired value was removed or overwritten
+	CacheEventExpire
+	// CacheEventEvict - a value was evicted to respect the cache limits
+	CacheEventEvict
+)
+
+func (t CacheEventType) String() string {
+	switch t {
+	case CacheEventSet:
+		return "set"
+	case CacheEventDelete:
+		return "delete"
+	case CacheEventExpire:
+		return "expire"
+	case CacheEventEvict:
+		return "evict"
+	default:
+		return "unknown"
+	}
+}
+
+// eventType maps the reason of a removal to the event reporting it
+func (r EvictReason) eventType() CacheEventType {
+	switch r {
+	case EvictExpired:
+		return CacheEventExpire
+	case EvictCapacity:
+		return CacheEventEvict
+	default:
+		return CacheEventDelete
+	}
+}
+
+// CacheEvent is a change of a cache entry delivered by Subscribe
+type CacheEvent[K comparable, V any] struct {
+	Type CacheEventType
+	Key  K
+	// OldValue is the previous value, valid only if HasOld is set
+	// For CacheEventSet it is set only when an actual value was overwritten
+	OldValue V
+	HasOld   bool
+	// NewValue is the stored value, valid only for CacheEventSet
+	NewValue V
+}
+
+// DefaultCacheEventBuffer is the channel capacity of a subscription unless WithEventBuffer sets another
+const DefaultCacheEventBuffer = 64
+
+// WithEventBuffer sets the channel capacity of subscriptions created by Subscribe
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithEventBuffer(size int) *Cache[K, V] {
+	c.eventBuffer = size
+	return c
+}
+
+// Subscribe returns a channel receiving a CacheEvent for every change of the cache
+// until ctx is done, after which the channel is closed
+// Events are sent without blocking the cache: when the buffer of a subscriber is full,
+// new events for it are dropped (the oldest buffered events are kept) and counted by DroppedEvents
+// Expiry is only observed when an expired value is removed (e.g. by Purge or the janitor)
+// or overwritten; events of concurrent operations may arrive in any order
+func (c *Cache[K, V]) Subscribe(ctx context.Context) <-chan CacheEvent[K, V] {
+	size := c.eventBuffer
+	if size <= 0 {
+		size = DefaultCacheEventBuffer
+	}
+	ch := make(chan CacheEvent[K, V], size)
+
+	c.subsMu.Lock()
+	if c.subs == nil {
+		c.subs = make(map[chan CacheEvent[K, V]]struct{})
+	}
+	c.subs[ch] = struct{}{}
+	c.subscribed.Store(true)
+	c.subsMu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		c.subsMu.Lock()
+		delete(c.subs, ch)
+		c.subscribed.Store(len(c.subs) > 0)
+		c.subsMu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+// DroppedEvents returns the number of events dropped because a subscriber was not keeping up
+func (c *Cache[K, V]) DroppedEvents() int64 {
+	return c.droppedEvents.Load()
+}
+
+// publish sends e to every subscriber without blocking
+func (c *Cache[K, V]) publish(e CacheEvent[K, V]) {
+	if !c.subscribed.Load() {
+		return
+	}
+
+	c.subsMu.RLock()
+	defer c.subsMu.RUnlock()
+
+	for ch := range c.subs {
+		select {
+		case ch <- e:
+		default:
+			c.droppedEvents.Add(1)
+		}
+	}
+}
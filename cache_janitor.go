@@ -0,0 +1,72 @@
+package notstd
+
+import (
+	"context"
+	"time"
+)
+
+// Purge physically removes expired and empty entries from the cache, including expired cached errors
+// Entries still servable under WithStaleWhileRevalidate are kept
+// Returns the number of removed entries
+func (c *Cache[K, V]) Purge() int {
+	c.mu.Lock()
+	defer c.unlock()
+
+	now := c.clock.Now()
+	count := 0
+	for k, cv := range c.storage {
+		if cv.isStale(now, c.maxStale) {
+			c.removeLocked(k, EvictExpired)
+			count++
+		}
+	}
+	return count
+}
+
+// StartJanitor starts a background goroutine that calls Purge every interval
+// The janitor runs until ctx is done or StopJanitor is called
+// Calling StartJanitor again replaces the running janitor
+// It panics if interval is not positive, like time.NewTicker
+func (c *Cache[K, V]) StartJanitor(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		panic("non-positive interval for Cache.StartJanitor")
+	}
+	c.StopJanitor()
+
+	c.janitorMu.Lock()
+	defer c.janitorMu.Unlock()
+
+	ctx, c.janitorCancel = context.WithCancel(ctx)
+	c.janitorWg.Add(1)
+	go c.runJanitor(ctx, interval)
+}
+
+// StopJanitor stops the background janitor and waits for it to exit
+func (c *Cache[K, V]) StopJanitor() {
+	c.janitorMu.Lock()
+	cancel := c.janitorCancel
+	c.janitorCancel = nil
+	c.janitorMu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	c.janitorWg.Wait()
+}
+
+// runJanitor is the janitor loop
+func (c *Cache[K, V]) runJanitor(ctx context.Context, interval time.Duration) {
+	defer c.janitorWg.Done()
+
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			c.Purge()
+		}
+	}
+}
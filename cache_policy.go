@@ -0,0 +1,397 @@
+package notstd
+
+import (
+	"container/heap"
+	"container/list"
+	"hash/maphash"
+)
+
+// EvictionPolicy decides which key a bounded Cache evicts
+// Implementations need not be thread-safe: Cache serializes all calls under its lock
+type EvictionPolicy[K comparable] interface {
+	// OnInsert is called when key is added to the cache
+	OnInsert(key K)
+	// OnAccess is called when key is read or overwritten
+	OnAccess(key K)
+	// OnRemove is called when key leaves the cache for any reason
+	OnRemove(key K)
+	// Victim returns the key to evict next, (zero, false) if the policy tracks no keys
+	// It is called before a new key is inserted into a full cache, and the returned
+	// key is always removed by the cache right after the call
+	Victim() (K, bool)
+}
+
+// LRUPolicy evicts the least recently used key
+type LRUPolicy[K comparable] struct {
+	order *list.List
+	index map[K]*list.Element
+}
+
+// NewLRUPolicy creates a new LRUPolicy instance
+func NewLRUPolicy[K comparable]() *LRUPolicy[K] {
+	return &LRUPolicy[K]{
+		order: list.New(),
+		index: make(map[K]*list.Element),
+	}
+}
+
+func (p *LRUPolicy[K]) OnInsert(key K) {
+	if el, ok := p.index[key]; ok {
+		p.order.MoveToFront(el)
+		return
+	}
+	p.index[key] = p.order.PushFront(key)
+}
+
+func (p *LRUPolicy[K]) OnAccess(key K) {
+	if el, ok := p.index[key]; ok {
+		p.order.MoveToFront(el)
+	}
+}
+
+func (p *LRUPolicy[K]) OnRemove(key K) {
+	if el, ok := p.index[key]; ok {
+		p.order.Remove(el)
+		delete(p.index, key)
+	}
+}
+
+func (p *LRUPolicy[K]) Victim() (K, bool) {
+	return listBack[K](p.order)
+}
+
+// FIFOPolicy evicts the oldest inserted key regardless of access
+type FIFOPolicy[K comparable] struct {
+	order *list.List
+	index map[K]*list.Element
+}
+
+// NewFIFOPolicy creates a new FIFOPolicy instance
+func NewFIFOPolicy[K comparable]() *FIFOPolicy[K] {
+	return &FIFOPolicy[K]{
+		order: list.New(),
+		index: make(map[K]*list.Element),
+	}
+}
+
+func (p *FIFOPolicy[K]) OnInsert(key K) {
+	if _, ok := p.index[key]; ok {
+		return
+	}
+	p.index[key] = p.order.PushFront(key)
+}
+
+func (p *FIFOPolicy[K]) OnAccess(K) {}
+
+func (p *FIFOPolicy[K]) OnRemove(key K) {
+	if el, ok := p.index[key]; ok {
+		p.order.Remove(el)
+		delete(p.index, key)
+	}
+}
+
+func (p *FIFOPolicy[K]) Victim() (K, bool) {
+	return listBack[K](p.order)
+}
+
+// LFUPolicy evicts the least frequently used key
+// Ties are broken by evicting the least recently used of them
+type LFUPolicy[K comparable] struct {
+	entries lfuHeap[K]
+	index   map[K]*lfuEntry[K]
+	tick    uint64
+}
+
+// NewLFUPolicy creates a new LFUPolicy instance
+func NewLFUPolicy[K comparable]() *LFUPolicy[K] {
+	return &LFUPolicy[K]{
+		index: make(map[K]*lfuEntry[K]),
+	}
+}
+
+func (p *LFUPolicy[K]) OnInsert(key K) {
+	if _, ok := p.index[key]; ok {
+		p.OnAccess(key)
+		return
+	}
+	p.tick++
+	e := &lfuEntry[K]{key: key, freq: 1, tick: p.tick}
+	p.index[key] = e
+	heap.Push(&p.entries, e)
+}
+
+func (p *LFUPolicy[K]) OnAccess(key K) {
+	e, ok := p.index[key]
+	if !ok {
+		return
+	}
+	p.tick++
+	e.freq++
+	e.tick = p.tick
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *LFUPolicy[K]) OnRemove(key K) {
+	e, ok := p.index[key]
+	if !ok {
+		return
+	}
+	heap.Remove(&p.entries, e.index)
+	delete(p.index, key)
+}
+
+func (p *LFUPolicy[K]) Victim() (K, bool) {
+	if len(p.entries) == 0 {
+		var zero K
+		return zero, false
+	}
+	return p.entries[0].key, true
+}
+
+type lfuEntry[K comparable] struct {
+	key   K
+	freq  uint64
+	tick  uint64
+	index int
+}
+
+// lfuHeap is a min-heap ordered by frequency, then by last access
+type lfuHeap[K comparable] []*lfuEntry[K]
+
+func (h lfuHeap[K]) Len() int { return len(h) }
+
+func (h lfuHeap[K]) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap[K]) Push(x any) {
+	e := x.(*lfuEntry[K])
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap[K]) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// TinyLFUPolicy is a W-TinyLFU style policy
+// New keys enter a small LRU window; once the cache is full, the key leaving the window
+// competes for a place in the segmented LRU main area against its eviction candidate,
+// and the one with the lower estimated access frequency is evicted
+type TinyLFUPolicy[K comparable] struct {
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	index     map[K]*list.Element
+
+	windowCap    int
+	protectedCap int
+
+	sketch *countMinSketch
+	seed   maphash.Seed
+}
+
+type tinyLFUEntry[K comparable] struct {
+	key     K
+	segment *list.List
+}
+
+// NewTinyLFUPolicy creates a new TinyLFUPolicy sized for capacity entries
+// capacity should match the limit passed to Cache.WithMaxEntries
+func NewTinyLFUPolicy[K comparable](capacity int) *TinyLFUPolicy[K] {
+	if capacity < 1 {
+		capacity = 1
+	}
+	windowCap := capacity / 100
+	if windowCap < 1 {
+		windowCap = 1
+	}
+	mainCap := capacity - windowCap
+	protectedCap := mainCap * 8 / 10
+
+	return &TinyLFUPolicy[K]{
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		index:        make(map[K]*list.Element),
+		windowCap:    windowCap,
+		protectedCap: protectedCap,
+		sketch:       newCountMinSketch(capacity),
+		seed:         maphash.MakeSeed(),
+	}
+}
+
+func (p *TinyLFUPolicy[K]) OnInsert(key K) {
+	if _, ok := p.index[key]; ok {
+		p.OnAccess(key)
+		return
+	}
+	p.sketch.Increment(p.hash(key))
+	p.index[key] = p.window.PushFront(&tinyLFUEntry[K]{key: key, segment: p.window})
+
+	// While the cache has room, keys leaving the window enter the main area unopposed
+	if p.window.Len() > p.windowCap {
+		p.move(p.window.Back(), p.probation)
+	}
+}
+
+func (p *TinyLFUPolicy[K]) OnAccess(key K) {
+	p.sketch.Increment(p.hash(key))
+
+	el, ok := p.index[key]
+	if !ok {
+		return
+	}
+	e := el.Value.(*tinyLFUEntry[K])
+	switch e.segment {
+	case p.window, p.protected:
+		e.segment.MoveToFront(el)
+	case p.probation:
+		// Promote to protected, demoting its LRU entry back to probation if it overflows
+		p.move(el, p.protected)
+		if p.protected.Len() > p.protectedCap {
+			p.move(p.protected.Back(), p.probation)
+		}
+	}
+}
+
+func (p *TinyLFUPolicy[K]) OnRemove(key K) {
+	el, ok := p.index[key]
+	if !ok {
+		return
+	}
+	el.Value.(*tinyLFUEntry[K]).segment.Remove(el)
+	delete(p.index, key)
+}
+
+func (p *TinyLFUPolicy[K]) Victim() (K, bool) {
+	if p.window.Len() >= p.windowCap {
+		victim := p.probation.Back()
+		if victim == nil {
+			victim = p.protected.Back()
+		}
+		if candidate := p.window.Back(); candidate != nil && victim != nil {
+			candidateKey := candidate.Value.(*tinyLFUEntry[K]).key
+			victimKey := victim.Value.(*tinyLFUEntry[K]).key
+			if p.sketch.Estimate(p.hash(candidateKey)) <= p.sketch.Estimate(p.hash(victimKey)) {
+				return candidateKey, true
+			}
+			// The candidate is admitted to the main area in place of the victim
+			p.move(candidate, p.probation)
+			return victimKey, true
+		}
+	}
+
+	for _, segment := range []*list.List{p.probation, p.protected, p.window} {
+		if el := segment.Back(); el != nil {
+			return el.Value.(*tinyLFUEntry[K]).key, true
+		}
+	}
+	var zero K
+	return zero, false
+}
+
+// move relinks el to the front of segment
+func (p *TinyLFUPolicy[K]) move(el *list.Element, segment *list.List) {
+	e := el.Value.(*tinyLFUEntry[K])
+	e.segment.Remove(el)
+	e.segment = segment
+	p.index[e.key] = segment.PushFront(e)
+}
+
+func (p *TinyLFUPolicy[K]) hash(key K) uint64 {
+	return maphash.Comparable(p.seed, key)
+}
+
+// countMinSketch is a 4-row frequency sketch with saturating counters and periodic aging
+type countMinSketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+// newCountMinSketch sizes rows to several counters per entry to keep collisions rare
+func newCountMinSketch(capacity int) *countMinSketch {
+	width := 16
+	for width < capacity*4 {
+		width <<= 1
+	}
+	s := &countMinSketch{
+		mask:    uint64(width - 1),
+		resetAt: capacity * 10,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+const countMinSketchMax = 15
+
+func (s *countMinSketch) Increment(h uint64) {
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.rows[i][idx] < countMinSketchMax {
+			s.rows[i][idx]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.age()
+	}
+}
+
+func (s *countMinSketch) Estimate(h uint64) uint8 {
+	est := uint8(countMinSketchMax)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(h, i)]; v < est {
+			est = v
+		}
+	}
+	return est
+}
+
+// age halves all counters so that stale popularity decays
+func (s *countMinSketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// index remixes h for every row, so that keys colliding in one row rarely collide in the others
+func (s *countMinSketch) index(h uint64, row int) uint64 {
+	// splitmix64 finalizer over a per-row offset
+	h += uint64(row+1) * 0x9e3779b97f4a7c15
+	h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h>>27) * 0x94d049bb133111eb
+	h ^= h >> 31
+	return h & s.mask
+}
+
+func listBack[K any](l *list.List) (K, bool) {
+	el := l.Back()
+	if el == nil {
+		var zero K
+		return zero, false
+	}
+	return el.Value.(K), true
+}
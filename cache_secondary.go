@@ -0,0 +1,200 @@
+package notstd
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SecondaryStore is a slower second cache layer behind Cache, e.g. a file or a remote key-value service
+// Implementations must be safe for concurrent use
+type SecondaryStore[K comparable, V any] interface {
+	// Get returns the value stored for key, or ErrNotFound if there is none
+	Get(ctx context.Context, key K) (V, error)
+	// Set stores value for key with ttl (0 = no expiration)
+	Set(ctx context.Context, key K, value V, ttl time.Duration) error
+	// Delete removes key; deleting a missing key is not an error
+	Delete(ctx context.Context, key K) error
+}
+
+// SecondaryWriteMode defines when writes to Cache reach its SecondaryStore
+type SecondaryWriteMode int
+
+const (
+	// SecondaryWriteThrough - writes reach the store before Set and Delete return
+	SecondaryWriteThrough SecondaryWriteMode = iota
+	// SecondaryWriteBehind - writes are queued and applied by a background goroutine,
+	// only the latest pending write of every key is applied, see FlushSecondary
+	SecondaryWriteBehind
+)
+
+// WithSecondaryStore puts store behind the cache
+// Local misses of GetDefault, Get and GetMany are looked up in store before defaultFn is called,
+// and values found there are cached locally without being written back
+// Set, SetWithTTL, SetWithTags, SetValue, Delete and values loaded by defaultFn are written to store
+// according to mode; Clear, InvalidateTag, Restore, evictions and expiry affect the local cache only
+// Without defaultFn, a key missing from both layers is reported as ErrNotFound
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithSecondaryStore(store SecondaryStore[K, V], mode SecondaryWriteMode) *Cache[K, V] {
+	c.secondary = store
+	c.secondaryMode = mode
+	return c
+}
+
+// WithSecondaryErrorHandler sets a handler for errors returned by the SecondaryStore
+// Lookup errors other than ErrNotFound are treated as misses, and failed writes are not retried
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithSecondaryErrorHandler(handler func(key K, err error)) *Cache[K, V] {
+	c.onSecondaryError = handler
+	return c
+}
+
+// FlushSecondary waits until all queued SecondaryWriteBehind writes are applied or ctx is done
+func (c *Cache[K, V]) FlushSecondary(ctx context.Context) error {
+	for {
+		c.writesMu.Lock()
+		if !c.flushing {
+			c.writesMu.Unlock()
+			return nil
+		}
+		flushed := c.flushed
+		c.writesMu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-flushed:
+		}
+	}
+}
+
+// secondaryWrite is a pending write-behind operation
+type secondaryWrite[V any] struct {
+	value  V
+	ttl    time.Duration
+	delete bool
+}
+
+// getSecondary looks key up in the secondary store and caches the value found there
+func (c *Cache[K, V]) getSecondary(ctx context.Context, key K) (V, bool) {
+	if c.secondary == nil {
+		var zero V
+		return zero, false
+	}
+
+	val, err := c.secondary.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			c.secondaryError(key, err)
+		}
+		var zero V
+		return zero, false
+	}
+	c.set(key, val, CacheDefaultTTL, nil)
+	return val, true
+}
+
+// writeSecondary sends a Set or Delete of key to the secondary store according to the write mode
+func (c *Cache[K, V]) writeSecondary(key K, w secondaryWrite[V]) {
+	if c.secondary == nil {
+		return
+	}
+	if w.ttl == CacheDefaultTTL {
+		w.ttl = c.timeout
+	}
+
+	if c.secondaryMode == SecondaryWriteThrough {
+		c.applySecondary(key, w)
+		return
+	}
+
+	c.writesMu.Lock()
+	defer c.writesMu.Unlock()
+
+	if c.writes == nil {
+		c.writes = make(map[K]secondaryWrite[V])
+	}
+	c.writes[key] = w
+	if !c.flushing {
+		c.flushing = true
+		c.flushed = make(chan struct{})
+		go c.flushWrites()
+	}
+}
+
+// flushWrites applies queued write-behind operations until the queue is empty
+func (c *Cache[K, V]) flushWrites() {
+	for {
+		c.writesMu.Lock()
+		writes := c.writes
+		c.writes = nil
+		if len(writes) == 0 {
+			c.flushing = false
+			close(c.flushed)
+			c.writesMu.Unlock()
+			return
+		}
+		c.writesMu.Unlock()
+
+		for key, w := range writes {
+			c.applySecondary(key, w)
+		}
+	}
+}
+
+func (c *Cache[K, V]) applySecondary(key K, w secondaryWrite[V]) {
+	ctx := context.Background()
+	var err error
+	if w.delete {
+		err = c.secondary.Delete(ctx, key)
+	} else {
+		err = c.secondary.Set(ctx, key, w.value, w.ttl)
+	}
+	if err != nil {
+		c.secondaryError(key, err)
+	}
+}
+
+func (c *Cache[K, V]) secondaryError(key K, err error) {
+	if c.onSecondaryError != nil {
+		c.onSecondaryError(key, err)
+	}
+}
+
+// LocalSecondaryStore is an in-process SecondaryStore backed by another Cache,
+// a stand-in for a remote store in tests and single-process setups
+type LocalSecondaryStore[K comparable, V any] struct {
+	cache *Cache[K, V]
+}
+
+// NewLocalSecondaryStore creates a new LocalSecondaryStore instance
+// cache: the cache holding the values (nil = a new unbounded cache without expiration)
+func NewLocalSecondaryStore[K comparable, V any](cache *Cache[K, V]) *LocalSecondaryStore[K, V] {
+	if cache == nil {
+		cache = NewCache[K, V](0, 0, nil, nil)
+	}
+	return &LocalSecondaryStore[K, V]{cache: cache}
+}
+
+// Cache returns the cache holding the values
+func (s *LocalSecondaryStore[K, V]) Cache() *Cache[K, V] {
+	return s.cache
+}
+
+func (s *LocalSecondaryStore[K, V]) Get(_ context.Context, key K) (V, error) {
+	val, ok := s.cache.GetNoDefault(key)
+	if !ok {
+		return val, ErrNotFound
+	}
+	return val, nil
+}
+
+func (s *LocalSecondaryStore[K, V]) Set(_ context.Context, key K, value V, ttl time.Duration) error {
+	s.cache.SetWithTTL(key, value, ttl)
+	return nil
+}
+
+func (s *LocalSecondaryStore[K, V]) Delete(_ context.Context, key K) error {
+	s.cache.Delete(key)
+	return nil
+}
@@ -0,0 +1,370 @@
+package notstd
+
+import (
+	"context"
+	"hash/maphash"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hasher maps a key to a hash used to pick its shard
+type Hasher[K comparable] func(K) uint64
+
+// NewMapHasher creates a Hasher based on hash/maphash with a random seed
+func NewMapHasher[K comparable]() Hasher[K] {
+	seed := maphash.MakeSeed()
+	return func(key K) uint64 {
+		return maphash.Comparable(seed, key)
+	}
+}
+
+// ShardedCache is a Cache split into independent shards, each with its own lock
+// It exposes the same API as Cache and can replace it on highly concurrent paths
+type ShardedCache[K comparable, V any] struct {
+	shards []*Cache[K, V]
+	hasher Hasher[K]
+
+	// Events dropped while merging the subscriptions of the shards, see Subscribe
+	droppedEvents atomic.Int64
+}
+
+// NewShardedCache creates a new ShardedCache instance
+// shards: number of shards (values below 1 are treated as 1)
+// hasher: function to pick the shard of a key (nil = NewMapHasher)
+// newShard: function to create each shard, e.g. NewCache(...).WithMaxEntries(n)
+// Limits such as WithMaxEntries apply to every shard separately,
+// and a bulk defaultFn used by GetMany is called once per shard
+func NewShardedCache[K comparable, V any](shards int, hasher Hasher[K], newShard func() *Cache[K, V]) *ShardedCache[K, V] {
+	if shards < 1 {
+		shards = 1
+	}
+	if hasher == nil {
+		hasher = NewMapHasher[K]()
+	}
+
+	s := &ShardedCache[K, V]{
+		shards: make([]*Cache[K, V], shards),
+		hasher: hasher,
+	}
+	for i := range s.shards {
+		s.shards[i] = newShard()
+	}
+	return s
+}
+
+// Shard returns the shard holding key
+func (s *ShardedCache[K, V]) Shard(key K) *Cache[K, V] {
+	return s.shards[s.hasher(key)%uint64(len(s.shards))]
+}
+
+// GetNoDefault retrieves a value from the cache by key without using defaultFn
+func (s *ShardedCache[K, V]) GetNoDefault(key K) (V, bool) {
+	return s.Shard(key).GetNoDefault(key)
+}
+
+// GetDefault retrieves a value from the cache by key, or uses defaultFn if not found
+func (s *ShardedCache[K, V]) GetDefault(key K) (V, bool, error) {
+	return s.Shard(key).GetDefault(key)
+}
+
+// GetDefaultCtx is GetDefault that stops waiting for defaultFn when ctx is done
+func (s *ShardedCache[K, V]) GetDefaultCtx(ctx context.Context, key K) (V, bool, error) {
+	return s.Shard(key).GetDefaultCtx(ctx, key)
+}
+
+// Get is a helper that calls GetDefault if defaultFn is set, otherwise GetNoDefault
+func (s *ShardedCache[K, V]) Get(key K) (V, bool, error) {
+	return s.Shard(key).Get(key)
+}
+
+// GetCtx is Get that stops waiting for defaultFn when ctx is done
+func (s *ShardedCache[K, V]) GetCtx(ctx context.Context, key K) (V, bool, error) {
+	return s.Shard(key).GetCtx(ctx, key)
+}
+
+// Set stores a value in the cache
+func (s *ShardedCache[K, V]) Set(key K, value V) bool {
+	return s.Shard(key).Set(key, value)
+}
+
+// SetWithTTL stores a value in the cache with its own expiration time
+func (s *ShardedCache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) bool {
+	return s.Shard(key).SetWithTTL(key, value, ttl)
+}
+
+// SetValue stores a value in the cache using keyFn to extract the key
+func (s *ShardedCache[K, V]) SetValue(value V) bool {
+	if s.shards[0].keyFn == nil {
+		return false
+	}
+	return s.Shard(s.GetKeyOf(value)).SetValue(value)
+}
+
+// Delete removes a value from the cache by key
+func (s *ShardedCache[K, V]) Delete(key K) bool {
+	return s.Shard(key).Delete(key)
+}
+
+// GetKeyOf extracts the key from a value using keyFn
+func (s *ShardedCache[K, V]) GetKeyOf(value V) K {
+	return s.shards[0].GetKeyOf(value)
+}
+
+// Has checks if a key exists in the cache and is not expired
+func (s *ShardedCache[K, V]) Has(key K) bool {
+	return s.Shard(key).Has(key)
+}
+
+// Clear removes all entries from every shard
+func (s *ShardedCache[K, V]) Clear() {
+	for _, shard := range s.shards {
+		shard.Clear()
+	}
+}
+
+// Len returns the number of non-expired entries in the cache
+func (s *ShardedCache[K, V]) Len() int {
+	count := 0
+	for _, shard := range s.shards {
+		count += shard.Len()
+	}
+	return count
+}
+
+// Keys returns all non-expired keys in the cache
+func (s *ShardedCache[K, V]) Keys() []K {
+	var keys []K
+	for _, shard := range s.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+// Values returns all non-expired values in the cache
+func (s *ShardedCache[K, V]) Values() []V {
+	var values []V
+	for _, shard := range s.shards {
+		values = append(values, shard.Values()...)
+	}
+	return values
+}
+
+// Range iterates over all non-expired key-value pairs shard by shard
+// If the callback returns false, iteration stops
+func (s *ShardedCache[K, V]) Range(fn func(key K, value V) bool) {
+	stopped := false
+	for _, shard := range s.shards {
+		shard.Range(func(key K, value V) bool {
+			stopped = !fn(key, value)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// SetMany stores multiple values in the cache
+// Returns the count of actual values that were overwritten
+func (s *ShardedCache[K, V]) SetMany(items map[K]V) int {
+	count := 0
+	for k, v := range items {
+		if s.Set(k, v) {
+			count++
+		}
+	}
+	return count
+}
+
+// GetMany retrieves multiple values, resolving the misses of each shard through its bulkFn
+func (s *ShardedCache[K, V]) GetMany(keys []K) (map[K]V, map[K]error) {
+	byShard := make(map[*Cache[K, V]][]K)
+	for _, k := range keys {
+		shard := s.Shard(k)
+		byShard[shard] = append(byShard[shard], k)
+	}
+
+	values := make(map[K]V, len(keys))
+	var errs map[K]error
+	for shard, shardKeys := range byShard {
+		shardValues, shardErrs := shard.GetMany(shardKeys)
+		for k, v := range shardValues {
+			values[k] = v
+		}
+		for k, err := range shardErrs {
+			if errs == nil {
+				errs = make(map[K]error)
+			}
+			errs[k] = err
+		}
+	}
+	return values, errs
+}
+
+// DeleteMany removes multiple keys from the cache
+// Returns the count of actual values that were deleted
+func (s *ShardedCache[K, V]) DeleteMany(keys []K) int {
+	count := 0
+	for _, k := range keys {
+		if s.Delete(k) {
+			count++
+		}
+	}
+	return count
+}
+
+// SetWithTags stores a value in the cache and attaches tags to it, see Cache.SetWithTags
+func (s *ShardedCache[K, V]) SetWithTags(key K, value V, tags ...string) bool {
+	return s.Shard(key).SetWithTags(key, value, tags...)
+}
+
+// InvalidateTag removes every entry carrying tag from all shards
+// Returns the count of actual values that were removed
+func (s *ShardedCache[K, V]) InvalidateTag(tag string) int {
+	count := 0
+	for _, shard := range s.shards {
+		count += shard.InvalidateTag(tag)
+	}
+	return count
+}
+
+// Update refreshes the cached value for a key by calling defaultFn
+func (s *ShardedCache[K, V]) Update(key K) error {
+	return s.Shard(key).Update(key)
+}
+
+// UpdateCtx is Update that stops waiting for defaultFn when ctx is done
+func (s *ShardedCache[K, V]) UpdateCtx(ctx context.Context, key K) error {
+	return s.Shard(key).UpdateCtx(ctx, key)
+}
+
+// Purge physically removes expired and empty entries from every shard
+// Returns the number of removed entries
+func (s *ShardedCache[K, V]) Purge() int {
+	count := 0
+	for _, shard := range s.shards {
+		count += shard.Purge()
+	}
+	return count
+}
+
+// StartJanitor starts the background janitor of every shard
+func (s *ShardedCache[K, V]) StartJanitor(ctx context.Context, interval time.Duration) {
+	for _, shard := range s.shards {
+		shard.StartJanitor(ctx, interval)
+	}
+}
+
+// StopJanitor stops the background janitor of every shard
+func (s *ShardedCache[K, V]) StopJanitor() {
+	for _, shard := range s.shards {
+		shard.StopJanitor()
+	}
+}
+
+// FlushSecondary waits until the queued write-behind writes of every shard are applied or ctx is done
+func (s *ShardedCache[K, V]) FlushSecondary(ctx context.Context) error {
+	for _, shard := range s.shards {
+		if err := shard.FlushSecondary(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TotalCost returns the total cost of the values in all shards
+// WithMaxCost applies to every shard separately
+func (s *ShardedCache[K, V]) TotalCost() int64 {
+	var total int64
+	for _, shard := range s.shards {
+		total += shard.TotalCost()
+	}
+	return total
+}
+
+// Stats returns the sum of the counters of all shards
+func (s *ShardedCache[K, V]) Stats() CacheStats {
+	var total CacheStats
+	for _, shard := range s.shards {
+		stats := shard.Stats()
+		total.Hits += stats.Hits
+		total.Misses += stats.Misses
+		total.LoadSuccesses += stats.LoadSuccesses
+		total.LoadFailures += stats.LoadFailures
+		total.TotalLoadTime += stats.TotalLoadTime
+		total.Evictions += stats.Evictions
+		total.Size += stats.Size
+	}
+	return total
+}
+
+// Snapshot writes the non-expired entries of all shards with their expiration times to w
+// in the same format as Cache.Snapshot, using the codec of the first shard
+func (s *ShardedCache[K, V]) Snapshot(w io.Writer) error {
+	var snapshot cacheSnapshot[K, V]
+	for _, shard := range s.shards {
+		snapshot.Entries = append(snapshot.Entries, shard.snapshotEntries()...)
+	}
+	return s.shards[0].snapshotCodec().Encode(w, snapshot)
+}
+
+// Restore reads entries written by Snapshot (of a ShardedCache or a Cache) from r
+// and stores each in its shard until its original expiration time, like Cache.Restore
+// Existing entries are kept unless overwritten by restored ones
+func (s *ShardedCache[K, V]) Restore(r io.Reader) error {
+	var snapshot cacheSnapshot[K, V]
+	if err := s.shards[0].snapshotCodec().Decode(r, &snapshot); err != nil {
+		return err
+	}
+
+	for _, e := range snapshot.Entries {
+		s.Shard(e.Key).restore(e)
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving the CacheEvent of every change of any shard
+// until ctx is done, after which the channel is closed
+// The events of the shards are merged into one channel with the buffer size of the first shard,
+// see Cache.WithEventBuffer; like Cache.Subscribe, new events are dropped while it is full
+// Events of different shards may arrive in any order
+func (s *ShardedCache[K, V]) Subscribe(ctx context.Context) <-chan CacheEvent[K, V] {
+	size := s.shards[0].eventBuffer
+	if size <= 0 {
+		size = DefaultCacheEventBuffer
+	}
+	out := make(chan CacheEvent[K, V], size)
+
+	var wg sync.WaitGroup
+	for _, shard := range s.shards {
+		wg.Add(1)
+		go func(events <-chan CacheEvent[K, V]) {
+			defer wg.Done()
+			for e := range events {
+				select {
+				case out <- e:
+				default:
+					s.droppedEvents.Add(1)
+				}
+			}
+		}(shard.Subscribe(ctx))
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// DroppedEvents returns the number of events dropped because a subscriber was not keeping up,
+// summed over all shards
+func (s *ShardedCache[K, V]) DroppedEvents() int64 {
+	total := s.droppedEvents.Load()
+	for _, shard := range s.shards {
+		total += shard.DroppedEvents()
+	}
+	return total
+}
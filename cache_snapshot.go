@@ -0,0 +1,109 @@
+package notstd
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// CacheCodec encodes and decodes cache snapshots
+type CacheCodec interface {
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Encode(w io.Writer, v any) error { return gob.NewEncoder(w).Encode(v) }
+func (gobCodec) Decode(r io.Reader, v any) error { return gob.NewDecoder(r).Decode(v) }
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(w io.Writer, v any) error { return json.NewEncoder(w).Encode(v) }
+func (jsonCodec) Decode(r io.Reader, v any) error { return json.NewDecoder(r).Decode(v) }
+
+var (
+	// GobCodec stores snapshots with encoding/gob; keys and values must be gob-encodable
+	GobCodec CacheCodec = gobCodec{}
+	// JSONCodec stores snapshots with encoding/json; keys and values must be JSON-encodable
+	JSONCodec CacheCodec = jsonCodec{}
+)
+
+// cacheSnapshot is the persisted form of the cache contents
+type cacheSnapshot[K comparable, V any] struct {
+	Entries []cacheSnapshotEntry[K, V]
+}
+
+type cacheSnapshotEntry[K comparable, V any] struct {
+	Key       K
+	Value     V
+	ExpiresAt time.Time // zero = no expiration
+}
+
+// WithCodec sets the codec used by Snapshot and Restore (default GobCodec)
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithCodec(codec CacheCodec) *Cache[K, V] {
+	c.codec = codec
+	return c
+}
+
+// Snapshot writes all non-expired entries with their expiration times to w
+func (c *Cache[K, V]) Snapshot(w io.Writer) error {
+	return c.snapshotCodec().Encode(w, cacheSnapshot[K, V]{Entries: c.snapshotEntries()})
+}
+
+// snapshotEntries returns all non-expired entries with their expiration times
+func (c *Cache[K, V]) snapshotEntries() []cacheSnapshotEntry[K, V] {
+	var entries []cacheSnapshotEntry[K, V]
+
+	c.mu.RLock()
+	now := c.clock.Now()
+	for k, cv := range c.storage {
+		val, expiresAt, hasValue := cv.entry()
+		if !hasValue {
+			continue
+		}
+
+		if !expiresAt.IsZero() && !expiresAt.After(now) {
+			continue
+		}
+		entries = append(entries, cacheSnapshotEntry[K, V]{Key: k, Value: val, ExpiresAt: expiresAt})
+	}
+	c.mu.RUnlock()
+
+	return entries
+}
+
+// Restore reads entries written by Snapshot from r and stores them until their original expiration times,
+// so the time passed since the snapshot counts against their TTLs; entries expired by now are skipped
+// Existing entries are kept unless overwritten by restored ones
+func (c *Cache[K, V]) Restore(r io.Reader) error {
+	var snapshot cacheSnapshot[K, V]
+	if err := c.snapshotCodec().Decode(r, &snapshot); err != nil {
+		return err
+	}
+
+	for _, e := range snapshot.Entries {
+		c.restore(e)
+	}
+	return nil
+}
+
+// restore stores a snapshot entry for the rest of its lifetime, skipping it if it has already expired
+func (c *Cache[K, V]) restore(e cacheSnapshotEntry[K, V]) {
+	var ttl time.Duration
+	if !e.ExpiresAt.IsZero() {
+		if ttl = e.ExpiresAt.Sub(c.clock.Now()); ttl <= 0 {
+			return
+		}
+	}
+	c.set(e.Key, e.Value, ttl, nil)
+}
+
+func (c *Cache[K, V]) snapshotCodec() CacheCodec {
+	if c.codec == nil {
+		return GobCodec
+	}
+	return c.codec
+}
@@ -0,0 +1,107 @@
+package notstd
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats is a point-in-time snapshot of cache counters
+type CacheStats struct {
+	Hits          int64         // lookups served from the cache, including stale values and cached errors
+	Misses        int64         // lookups that found no usable entry
+	LoadSuccesses int64         // successful defaultFn calls (a bulk call counts once)
+	LoadFailures  int64         // failed defaultFn calls
+	TotalLoadTime time.Duration // time spent in defaultFn calls
+	Evictions     int64         // entries removed by the cache itself due to capacity or expiry
+	Size          int           // stored entries, including expired ones not purged yet
+}
+
+// HitRatio returns Hits / (Hits + Misses), 0 if there were no lookups
+func (s CacheStats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// AverageLoadTime returns the mean duration of a defaultFn call, 0 if there were no loads
+func (s CacheStats) AverageLoadTime() time.Duration {
+	loads := s.LoadSuccesses + s.LoadFailures
+	if loads == 0 {
+		return 0
+	}
+	return s.TotalLoadTime / time.Duration(loads)
+}
+
+// cacheCounters are the atomically maintained counters behind CacheStats
+type cacheCounters struct {
+	hits          atomic.Int64
+	misses        atomic.Int64
+	loadSuccesses atomic.Int64
+	loadFailures  atomic.Int64
+	loadTime      atomic.Int64
+	evictions     atomic.Int64
+}
+
+func (cc *cacheCounters) hit() {
+	cc.hits.Add(1)
+}
+
+func (cc *cacheCounters) miss() {
+	cc.misses.Add(1)
+}
+
+func (cc *cacheCounters) lookup(ok bool) {
+	if ok {
+		cc.hit()
+	} else {
+		cc.miss()
+	}
+}
+
+func (cc *cacheCounters) load(d time.Duration, err error) {
+	cc.loadTime.Add(int64(d))
+	if err != nil {
+		cc.loadFailures.Add(1)
+	} else {
+		cc.loadSuccesses.Add(1)
+	}
+}
+
+func (cc *cacheCounters) evict() {
+	cc.evictions.Add(1)
+}
+
+func (cc *cacheCounters) snapshot(size int) CacheStats {
+	return CacheStats{
+		Hits:          cc.hits.Load(),
+		Misses:        cc.misses.Load(),
+		LoadSuccesses: cc.loadSuccesses.Load(),
+		LoadFailures:  cc.loadFailures.Load(),
+		TotalLoadTime: time.Duration(cc.loadTime.Load()),
+		Evictions:     cc.evictions.Load(),
+		Size:          size,
+	}
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *Cache[K, V]) Stats() CacheStats {
+	c.mu.RLock()
+	size := len(c.storage)
+	c.mu.RUnlock()
+
+	return c.stats.snapshot(size)
+}
+
+// Stats returns a snapshot of the CacheValue counters; Size is 1 while a value is stored
+func (cv *CacheValue[T]) Stats() CacheStats {
+	cv.mu.RLock()
+	size := 0
+	if cv.hasValue {
+		size = 1
+	}
+	cv.mu.RUnlock()
+
+	return cv.stats.snapshot(size)
+}
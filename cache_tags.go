@@ -0,0 +1,95 @@
+package notstd
+
+import "slices"
+
+// WithTagFn sets a function that computes the tags of every stored value,
+// including values produced by defaultFn, see InvalidateTag
+// Must be called before the cache is used
+func (c *Cache[K, V]) WithTagFn(tagFn func(key K, value V) []string) *Cache[K, V] {
+	c.tagFn = tagFn
+	return c
+}
+
+// SetWithTags stores a value in the cache and attaches tags to it in addition to those from tagFn
+// Tags belong to the stored value: overwriting it replaces them
+// Returns true if an actual (non-expired) value was overwritten
+func (c *Cache[K, V]) SetWithTags(key K, value V, tags ...string) bool {
+	wasActual := c.set(key, value, CacheDefaultTTL, tags)
+	c.writeSecondary(key, secondaryWrite[V]{value: value, ttl: CacheDefaultTTL})
+	return wasActual
+}
+
+// InvalidateTag removes every entry carrying tag
+// Returns the count of actual values that were removed
+func (c *Cache[K, V]) InvalidateTag(tag string) int {
+	c.mu.Lock()
+	defer c.unlock()
+
+	count := 0
+	for k := range c.tagIndex[tag] {
+		if c.storage[k].Has() {
+			count++
+		}
+		c.removeLocked(k, EvictDeleted)
+	}
+	return count
+}
+
+// tag replaces the tags of key with tags plus those from tagFn
+func (c *Cache[K, V]) tag(key K, value V, tags []string) {
+	tags = c.tagsOf(key, value, tags)
+	if len(tags) == 0 && !c.tagged.Load() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.untagLocked(key)
+	if _, ok := c.storage[key]; ok {
+		c.tagLocked(key, tags)
+	}
+}
+
+// tagsOf returns tags plus the tags tagFn computes for value
+func (c *Cache[K, V]) tagsOf(key K, value V, tags []string) []string {
+	tags = slices.Clone(tags)
+	if c.tagFn != nil {
+		tags = append(tags, c.tagFn(key, value)...)
+	}
+	return tags
+}
+
+// tagLocked attaches tags to an untagged key; caller must hold the write lock
+func (c *Cache[K, V]) tagLocked(key K, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+
+	if c.tagIndex == nil {
+		c.tagIndex = make(map[string]Set[K])
+		c.keyTags = make(map[K][]string)
+	}
+	for _, t := range tags {
+		keys, ok := c.tagIndex[t]
+		if !ok {
+			keys = make(Set[K])
+			c.tagIndex[t] = keys
+		}
+		keys.Add(key)
+	}
+	c.keyTags[key] = tags
+	c.tagged.Store(true)
+}
+
+// untagLocked detaches all tags from key; caller must hold the write lock
+func (c *Cache[K, V]) untagLocked(key K) {
+	for _, t := range c.keyTags[key] {
+		keys := c.tagIndex[t]
+		keys.Delete(key)
+		if keys.Len() == 0 {
+			delete(c.tagIndex, t)
+		}
+	}
+	delete(c.keyTags, key)
+}
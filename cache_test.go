@@ -1,7 +1,13 @@
 package notstd
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -348,3 +354,1756 @@ func TestCache(t *testing.T) {
 		}
 	})
 }
+
+func TestCacheMaxEntries(t *testing.T) {
+	t.Run("evicts least recently used", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil).WithMaxEntries(2)
+
+		cache.Set("one", 1)
+		cache.Set("two", 2)
+
+		// Touch "one" so "two" becomes the eviction candidate
+		if _, ok := cache.GetNoDefault("one"); !ok {
+			t.Fatal("expected 'one' to be cached")
+		}
+
+		cache.Set("three", 3)
+
+		if cache.Len() != 2 {
+			t.Errorf("expected 2 items, got %d", cache.Len())
+		}
+		if cache.Has("two") {
+			t.Error("expected 'two' to be evicted")
+		}
+		if !cache.Has("one") || !cache.Has("three") {
+			t.Error("expected 'one' and 'three' to remain")
+		}
+	})
+
+	t.Run("default function counts as access", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			return len(key), nil
+		}).WithMaxEntries(2)
+
+		if _, _, err := cache.GetDefault("a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, _, err := cache.GetDefault("bb"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, _, err := cache.GetDefault("a"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, _, err := cache.GetDefault("ccc"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if cache.Has("bb") {
+			t.Error("expected 'bb' to be evicted")
+		}
+		if !cache.Has("a") || !cache.Has("ccc") {
+			t.Error("expected 'a' and 'ccc' to remain")
+		}
+	})
+
+	t.Run("shrinks existing entries", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil)
+		cache.SetMany(map[string]int{"one": 1, "two": 2, "three": 3})
+
+		cache.WithMaxEntries(1)
+		if cache.Len() != 1 {
+			t.Errorf("expected 1 item, got %d", cache.Len())
+		}
+	})
+}
+
+func TestCacheEvictionPolicy(t *testing.T) {
+	t.Run("FIFO ignores access", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithEvictionPolicy(NewFIFOPolicy[string]()).
+			WithMaxEntries(2)
+
+		cache.Set("one", 1)
+		cache.Set("two", 2)
+		cache.GetNoDefault("one")
+		cache.Set("three", 3)
+
+		if cache.Has("one") {
+			t.Error("expected 'one' to be evicted as the oldest entry")
+		}
+		if !cache.Has("two") || !cache.Has("three") {
+			t.Error("expected 'two' and 'three' to remain")
+		}
+	})
+
+	t.Run("LFU evicts least frequently used", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithEvictionPolicy(NewLFUPolicy[string]()).
+			WithMaxEntries(2)
+
+		cache.Set("one", 1)
+		cache.Set("two", 2)
+		for i := 0; i < 3; i++ {
+			cache.GetNoDefault("one")
+		}
+		cache.GetNoDefault("two")
+		cache.Set("three", 3)
+
+		if cache.Has("two") {
+			t.Error("expected 'two' to be evicted")
+		}
+		if !cache.Has("one") || !cache.Has("three") {
+			t.Error("expected 'one' and 'three' to remain")
+		}
+	})
+
+	t.Run("TinyLFU keeps hot keys under a scan", func(t *testing.T) {
+		const capacity = 100
+		cache := NewCache[int, int](0, 0, nil, nil).
+			WithEvictionPolicy(NewTinyLFUPolicy[int](capacity)).
+			WithMaxEntries(capacity)
+
+		// Hot keys are read repeatedly before a one-off scan of cold keys
+		for k := 0; k < 10; k++ {
+			cache.Set(k, k)
+			for i := 0; i < 5; i++ {
+				cache.GetNoDefault(k)
+			}
+		}
+		for k := 1000; k < 1500; k++ {
+			cache.Set(k, k)
+		}
+
+		if cache.Len() != capacity {
+			t.Errorf("expected %d items, got %d", capacity, cache.Len())
+		}
+		for k := 0; k < 10; k++ {
+			if !cache.Has(k) {
+				t.Errorf("expected hot key %d to survive the scan", k)
+			}
+		}
+	})
+}
+
+func TestCachePurge(t *testing.T) {
+	t.Run("manual purge", func(t *testing.T) {
+		cache := NewCache[string, int](50*time.Millisecond, 0, nil, nil)
+
+		cache.Set("one", 1)
+		cache.Set("two", 2)
+		time.Sleep(80 * time.Millisecond)
+		cache.Set("three", 3)
+
+		if removed := cache.Purge(); removed != 2 {
+			t.Errorf("expected 2 purged entries, got %d", removed)
+		}
+		if len(cache.storage) != 1 {
+			t.Errorf("expected 1 stored entry, got %d", len(cache.storage))
+		}
+	})
+
+	t.Run("misses do not leave entries behind", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			return 0, errors.New("test error")
+		})
+
+		cache.GetDefault("missing")
+		cache.GetNoDefault("missing")
+		if len(cache.storage) != 0 {
+			t.Errorf("expected no stored entries, got %d", len(cache.storage))
+		}
+	})
+
+	t.Run("concurrent set is not lost", func(t *testing.T) {
+		cache := NewCache[int, int](0, 0, nil, nil)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 1000; i++ {
+				cache.Set(i, i)
+			}
+		}()
+
+	purge:
+		for {
+			select {
+			case <-done:
+				break purge
+			default:
+				cache.Purge()
+			}
+		}
+
+		for i := 0; i < 1000; i++ {
+			if v, ok := cache.GetNoDefault(i); !ok || v != i {
+				t.Fatalf("expected %d to be stored, got %d, %v", i, v, ok)
+			}
+		}
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		cache := NewCache[string, int](20*time.Millisecond, 0, nil, nil)
+		cache.StartJanitor(context.Background(), 10*time.Millisecond)
+		defer cache.StopJanitor()
+
+		cache.Set("one", 1)
+		time.Sleep(80 * time.Millisecond)
+
+		cache.mu.RLock()
+		n := len(cache.storage)
+		cache.mu.RUnlock()
+		if n != 0 {
+			t.Errorf("expected janitor to purge expired entry, got %d stored", n)
+		}
+	})
+
+	t.Run("janitor rejects non-positive interval", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil)
+		defer func() {
+			if recover() == nil {
+				t.Error("expected StartJanitor to panic")
+			}
+		}()
+		cache.StartJanitor(context.Background(), 0)
+	})
+}
+
+func TestCacheSingleflight(t *testing.T) {
+	const callers = 20
+
+	t.Run("Cache shares one load per key", func(t *testing.T) {
+		var calls int32
+		release := make(chan struct{})
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return len(key), nil
+		})
+
+		var wg sync.WaitGroup
+		results := make([]int, callers)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				val, _, err := cache.GetDefault("hello")
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				results[i] = val
+			}(i)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected defaultFn to be called once, got %d", n)
+		}
+		for i, v := range results {
+			if v != 5 {
+				t.Errorf("caller %d expected 5, got %d", i, v)
+			}
+		}
+	})
+
+	t.Run("Cache shares errors", func(t *testing.T) {
+		var calls int32
+		release := make(chan struct{})
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return 0, errors.New("test error")
+		})
+
+		var wg sync.WaitGroup
+		var failed int32
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := cache.GetDefault("key"); err != nil {
+					atomic.AddInt32(&failed, 1)
+				}
+			}()
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected defaultFn to be called once, got %d", n)
+		}
+		if failed != callers {
+			t.Errorf("expected all %d callers to fail, got %d", callers, failed)
+		}
+	})
+
+	t.Run("CacheValue shares one load", func(t *testing.T) {
+		var calls int32
+		release := make(chan struct{})
+		cv := NewCacheValue[int](0, func() (int, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return 42, nil
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < callers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if val, _, err := cv.GetDefault(); err != nil || val != 42 {
+					t.Errorf("expected 42, got %v, %v", val, err)
+				}
+			}()
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected defaultFn to be called once, got %d", n)
+		}
+	})
+}
+
+func TestCacheBackgroundRefresh(t *testing.T) {
+	// newCache returns a cache whose loader blocks until a value is sent on the returned channel
+	newCache := func(clock Clock) (*Cache[string, int32], chan<- int32, *int32) {
+		values := make(chan int32)
+		calls := new(int32)
+		cache := NewCache[string, int32](time.Minute, 0, nil, func(key string) (int32, error) {
+			atomic.AddInt32(calls, 1)
+			return <-values, nil
+		}).WithClock(clock)
+		return cache, values, calls
+	}
+
+	// waitSet waits until the cache reports a Set of want
+	waitSet := func(t *testing.T, events <-chan CacheEvent[string, int32], want int32) {
+		t.Helper()
+		for {
+			select {
+			case e := <-events:
+				if e.Type == CacheEventSet && e.NewValue == want {
+					return
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for %d to be stored", want)
+			}
+		}
+	}
+
+	t.Run("stale while revalidate", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache, values, calls := newCache(clock)
+		cache.WithStaleWhileRevalidate(time.Hour)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := cache.Subscribe(ctx)
+
+		cache.Set("key", 1)
+		clock.Advance(2 * time.Minute)
+
+		// Expired value is served immediately while a reload runs in the background
+		val, ok, err := cache.GetDefault("key")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ok || val != 1 {
+			t.Errorf("expected stale 1 with ok=false, got %d, %v", val, ok)
+		}
+
+		values <- 2
+		waitSet(t, events, 2)
+		if val, ok := cache.GetNoDefault("key"); !ok || val != 2 {
+			t.Errorf("expected refreshed 2, got %d, %v", val, ok)
+		}
+		if n := atomic.LoadInt32(calls); n != 1 {
+			t.Errorf("expected 1 load, got %d", n)
+		}
+	})
+
+	t.Run("stale value is not served past maxStale", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache, values, _ := newCache(clock)
+		cache.WithStaleWhileRevalidate(time.Minute)
+
+		cache.Set("key", 1)
+		clock.Advance(3 * time.Minute)
+
+		go func() { values <- 2 }()
+		if val, _, _ := cache.GetDefault("key"); val != 2 {
+			t.Errorf("expected synchronous reload to 2, got %d", val)
+		}
+	})
+
+	t.Run("refresh ahead", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache, values, calls := newCache(clock)
+		cache.WithRefreshAhead(30 * time.Second)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := cache.Subscribe(ctx)
+
+		cache.Set("key", 1)
+
+		// Outside the window: no reload
+		clock.Advance(20 * time.Second)
+		if val, ok, _ := cache.GetDefault("key"); !ok || val != 1 {
+			t.Errorf("expected cached 1, got %d, %v", val, ok)
+		}
+
+		// Inside the window: cached value is returned and reloaded in the background
+		clock.Advance(20 * time.Second)
+		if val, ok, _ := cache.GetDefault("key"); !ok || val != 1 {
+			t.Errorf("expected cached 1, got %d, %v", val, ok)
+		}
+
+		values <- 2
+		waitSet(t, events, 2)
+		if val, ok := cache.GetNoDefault("key"); !ok || val != 2 {
+			t.Errorf("expected refreshed 2, got %d, %v", val, ok)
+		}
+		if n := atomic.LoadInt32(calls); n != 1 {
+			t.Errorf("expected 1 load, got %d", n)
+		}
+	})
+}
+
+func TestCacheTTL(t *testing.T) {
+	t.Run("SetWithTTL overrides cache timeout", func(t *testing.T) {
+		cache := NewCache[string, int](time.Hour, 0, nil, nil)
+
+		cache.SetWithTTL("short", 1, 50*time.Millisecond)
+		cache.Set("default", 2)
+		cache.SetWithTTL("forever", 3, 0)
+
+		time.Sleep(80 * time.Millisecond)
+
+		if cache.Has("short") {
+			t.Error("expected 'short' to be expired")
+		}
+		if !cache.Has("default") || !cache.Has("forever") {
+			t.Error("expected 'default' and 'forever' to remain")
+		}
+	})
+
+	t.Run("CacheValue SetWithTTL", func(t *testing.T) {
+		cv := NewCacheValue[string](0, nil)
+
+		cv.SetWithTTL("test", 50*time.Millisecond)
+		time.Sleep(80 * time.Millisecond)
+		if cv.Has() {
+			t.Error("expected value to be expired")
+		}
+
+		// Plain Set falls back to the CacheValue timeout (no expiration)
+		cv.SetWithTTL("test", 50*time.Millisecond)
+		cv.Set("test")
+		time.Sleep(80 * time.Millisecond)
+		if !cv.Has() {
+			t.Error("expected value without expiration")
+		}
+	})
+
+	t.Run("TTL from default function", func(t *testing.T) {
+		cache := NewCache[string, string](time.Hour, 0, nil, nil).
+			WithTTLDefaultFn(func(key string) (string, time.Duration, error) {
+				if key == "token" {
+					return "secret", 50 * time.Millisecond, nil
+				}
+				return key, CacheDefaultTTL, nil
+			})
+
+		if val, _, err := cache.GetDefault("token"); err != nil || val != "secret" {
+			t.Fatalf("expected 'secret', got %v, %v", val, err)
+		}
+		cache.GetDefault("other")
+
+		time.Sleep(80 * time.Millisecond)
+
+		if cache.Has("token") {
+			t.Error("expected 'token' to be expired")
+		}
+		if !cache.Has("other") {
+			t.Error("expected 'other' to use the cache timeout")
+		}
+	})
+
+	t.Run("CacheValue TTL from default function", func(t *testing.T) {
+		cv := NewCacheValue[int](0, nil).WithTTLDefaultFn(func() (int, time.Duration, error) {
+			return 42, 50 * time.Millisecond, nil
+		})
+
+		if val, _, err := cv.GetDefault(); err != nil || val != 42 {
+			t.Fatalf("expected 42, got %v, %v", val, err)
+		}
+		time.Sleep(80 * time.Millisecond)
+		if cv.Has() {
+			t.Error("expected value to be expired")
+		}
+	})
+
+	t.Run("negative TTL means already expired", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		var loads atomic.Int32
+		cache := NewCache[string, string](time.Hour, 0, nil, nil).
+			WithClock(clock).
+			WithTTLDefaultFn(func(key string) (string, time.Duration, error) {
+				loads.Add(1)
+				// e.g. time.Until(exp) of a token that has already expired
+				return key, -5 * time.Second, nil
+			})
+
+		cache.SetWithTTL("set", "v", -time.Second)
+		cache.SetWithTTL("tiny", "v", -time.Nanosecond)
+		if cache.Has("set") || cache.Has("tiny") {
+			t.Error("expected values with negative TTL to be expired")
+		}
+
+		if val, _, err := cache.GetDefault("token"); err != nil || val != "token" {
+			t.Fatalf("expected 'token', got %v, %v", val, err)
+		}
+		clock.Advance(24 * time.Hour)
+		if cache.Has("token") {
+			t.Error("expected loaded value with negative TTL to be expired")
+		}
+		cache.GetDefault("token")
+		if loads.Load() != 2 {
+			t.Errorf("expected expired token to be reloaded, got %d loads", loads.Load())
+		}
+
+		cv := NewCacheValue[int](0, nil)
+		cv.SetWithTTL(1, -time.Second)
+		if cv.Has() {
+			t.Error("expected CacheValue with negative TTL to be expired")
+		}
+	})
+}
+
+func TestCacheContext(t *testing.T) {
+	type ctxKey struct{}
+
+	t.Run("cancelled caller does not poison other waiters", func(t *testing.T) {
+		release := make(chan struct{})
+		cache := NewCache[string, string](0, 0, nil, nil).
+			WithLoader(func(ctx context.Context, key string) (string, error) {
+				<-release
+				if err := ctx.Err(); err != nil {
+					return "", err
+				}
+				return ctx.Value(ctxKey{}).(string), nil
+			})
+
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "from ctx"))
+		errCh := make(chan error, 1)
+		go func() {
+			_, _, err := cache.GetDefaultCtx(ctx, "key")
+			errCh <- err
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		resCh := make(chan string, 1)
+		go func() {
+			val, _, _ := cache.GetCtx(context.Background(), "key")
+			resCh <- val
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+		if err := <-errCh; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+
+		close(release)
+		if val := <-resCh; val != "from ctx" {
+			t.Errorf("expected 'from ctx', got %q", val)
+		}
+		if val, ok := cache.GetNoDefault("key"); !ok || val != "from ctx" {
+			t.Errorf("expected value to be cached, got %q, %v", val, ok)
+		}
+	})
+
+	t.Run("load is cancelled when its only caller gives up", func(t *testing.T) {
+		observed := make(chan error, 1)
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithNegativeCache(time.Hour, func(error) bool { return true }).
+			WithLoader(func(ctx context.Context, key string) (int, error) {
+				<-ctx.Done()
+				observed <- ctx.Err()
+				return 0, ctx.Err()
+			})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if _, _, err := cache.GetDefaultCtx(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+
+		select {
+		case err := <-observed:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected the loader ctx to be cancelled, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the loader to observe ctx.Done()")
+		}
+
+		// The abandoned call neither poisons the key nor blocks a fresh load
+		time.Sleep(10 * time.Millisecond)
+		if err := cache.cachedError("key"); err != nil {
+			t.Errorf("expected the cancellation not to be cached, got %v", err)
+		}
+		ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel2()
+		cache.GetDefaultCtx(ctx2, "key")
+		select {
+		case <-observed:
+		case <-time.After(time.Second):
+			t.Fatal("expected a fresh load to be started and cancelled")
+		}
+	})
+
+	t.Run("UpdateCtx deadline", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithLoader(func(ctx context.Context, key string) (int, error) {
+				time.Sleep(50 * time.Millisecond)
+				return 1, nil
+			})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := cache.UpdateCtx(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected context.DeadlineExceeded, got %v", err)
+		}
+	})
+}
+
+func TestCacheGetMany(t *testing.T) {
+	t.Run("bulk default function", func(t *testing.T) {
+		var requested [][]string
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithBulkDefaultFn(func(keys []string) (map[string]int, error) {
+				requested = append(requested, keys)
+				res := make(map[string]int, len(keys))
+				for _, k := range keys {
+					if k != "missing" {
+						res[k] = len(k)
+					}
+				}
+				return res, nil
+			})
+
+		cache.Set("cached", 100)
+
+		values, errs := cache.GetMany([]string{"cached", "a", "bb", "missing", "a"})
+		if len(requested) != 1 || len(requested[0]) != 3 {
+			t.Fatalf("expected one bulk call for 3 keys, got %v", requested)
+		}
+		if values["cached"] != 100 || values["a"] != 1 || values["bb"] != 2 {
+			t.Errorf("unexpected values: %v", values)
+		}
+		if len(errs) != 1 || !errors.Is(errs["missing"], ErrNotFound) {
+			t.Errorf("expected ErrNotFound for 'missing', got %v", errs)
+		}
+
+		// Loaded values are cached
+		if !cache.Has("a") || !cache.Has("bb") {
+			t.Error("expected loaded values to be cached")
+		}
+	})
+
+	t.Run("bulk failure is reported per key", func(t *testing.T) {
+		testErr := errors.New("test error")
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithBulkDefaultFn(func(keys []string) (map[string]int, error) {
+				return nil, testErr
+			})
+		cache.Set("cached", 1)
+
+		values, errs := cache.GetMany([]string{"cached", "one", "two"})
+		if len(values) != 1 || values["cached"] != 1 {
+			t.Errorf("expected only the cached value, got %v", values)
+		}
+		if len(errs) != 2 || !errors.Is(errs["one"], testErr) || !errors.Is(errs["two"], testErr) {
+			t.Errorf("expected test error for both misses, got %v", errs)
+		}
+	})
+
+	t.Run("falls back to default function", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			if key == "error" {
+				return 0, errors.New("test error")
+			}
+			return len(key), nil
+		})
+
+		values, errs := cache.GetMany([]string{"abc", "error"})
+		if values["abc"] != 3 {
+			t.Errorf("expected 3, got %v", values)
+		}
+		if errs["error"] == nil {
+			t.Error("expected error for 'error'")
+		}
+	})
+}
+
+func TestCacheNegative(t *testing.T) {
+	t.Run("caches not found", func(t *testing.T) {
+		var calls int32
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return 0, fmt.Errorf("user %s: %w", key, ErrNotFound)
+		}).WithNegativeCache(50*time.Millisecond, nil)
+
+		for i := 0; i < 3; i++ {
+			if _, _, err := cache.Get("missing"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected 1 call, got %d", n)
+		}
+		if cache.Has("missing") || cache.Len() != 0 {
+			t.Error("expected cached error not to count as a value")
+		}
+
+		// Cached error expires
+		time.Sleep(80 * time.Millisecond)
+		cache.Get("missing")
+		if n := atomic.LoadInt32(&calls); n != 2 {
+			t.Errorf("expected 2 calls after expiry, got %d", n)
+		}
+
+		// Set replaces the cached error
+		cache.Set("missing", 1)
+		if val, _, err := cache.Get("missing"); err != nil || val != 1 {
+			t.Errorf("expected 1, got %v, %v", val, err)
+		}
+	})
+
+	t.Run("other errors are not cached by default", func(t *testing.T) {
+		var calls int32
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return 0, errors.New("test error")
+		}).WithNegativeCache(time.Minute, nil)
+
+		cache.Get("key")
+		cache.Get("key")
+		if n := atomic.LoadInt32(&calls); n != 2 {
+			t.Errorf("expected 2 calls, got %d", n)
+		}
+	})
+
+	t.Run("custom match", func(t *testing.T) {
+		var calls int32
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return 0, errors.New("test error")
+		}).WithNegativeCache(time.Minute, func(err error) bool { return true })
+
+		cache.Get("key")
+		if _, _, err := cache.Get("key"); err == nil {
+			t.Error("expected cached error")
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected 1 call, got %d", n)
+		}
+	})
+
+	t.Run("GetMany caches keys missing from bulk result", func(t *testing.T) {
+		var calls int32
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithNegativeCache(time.Minute, nil).
+			WithBulkDefaultFn(func(keys []string) (map[string]int, error) {
+				atomic.AddInt32(&calls, 1)
+				return map[string]int{}, nil
+			})
+
+		cache.GetMany([]string{"missing"})
+		_, errs := cache.GetMany([]string{"missing"})
+		if !errors.Is(errs["missing"], ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", errs)
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected 1 bulk call, got %d", n)
+		}
+	})
+
+	t.Run("purge keeps unexpired errors", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			return 0, ErrNotFound
+		}).WithNegativeCache(50*time.Millisecond, nil)
+
+		cache.Get("missing")
+		if removed := cache.Purge(); removed != 0 {
+			t.Errorf("expected nothing purged, got %d", removed)
+		}
+		time.Sleep(80 * time.Millisecond)
+		if removed := cache.Purge(); removed != 1 {
+			t.Errorf("expected expired error purged, got %d", removed)
+		}
+	})
+
+	t.Run("failed background refresh keeps stale value", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		errs := make(chan error)
+		defer close(errs)
+		cache := NewCache[string, int](time.Minute, 0, nil, func(key string) (int, error) {
+			return 0, <-errs
+		}).WithClock(clock).WithStaleWhileRevalidate(time.Minute).WithNegativeCache(time.Minute, nil)
+
+		cache.Set("key", 1)
+		clock.Advance(90 * time.Second)
+		if val, _, err := cache.GetDefault("key"); err != nil || val != 1 {
+			t.Fatalf("expected stale 1, got %d, %v", val, err)
+		}
+
+		errs <- ErrNotFound
+		deadline := time.Now().Add(time.Second)
+		for {
+			if _, ok := cache.loads.Get("key"); !ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected background refresh to finish")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		if err := cache.cachedError("key"); err != nil {
+			t.Errorf("expected stale value to be kept, got cached error %v", err)
+		}
+		if val, _, err := cache.GetDefault("key"); err != nil || val != 1 {
+			t.Errorf("expected stale 1, got %d, %v", val, err)
+		}
+	})
+}
+
+func TestCacheEvictHandler(t *testing.T) {
+	type eviction struct {
+		key    string
+		value  int
+		reason EvictReason
+	}
+
+	newCache := func(timeout time.Duration) (*Cache[string, int], *[]eviction) {
+		var evictions []eviction
+		cache := NewCache[string, int](timeout, 0, nil, nil).
+			WithEvictHandler(func(key string, value int, reason EvictReason) {
+				evictions = append(evictions, eviction{key, value, reason})
+			})
+		return cache, &evictions
+	}
+
+	t.Run("delete, replace and clear", func(t *testing.T) {
+		cache, evictions := newCache(0)
+
+		cache.Set("one", 1)
+		cache.Set("one", 10)
+		cache.Set("two", 2)
+		cache.Delete("two")
+		cache.Delete("missing")
+		cache.Clear()
+
+		expected := []eviction{
+			{"one", 1, EvictReplaced},
+			{"two", 2, EvictDeleted},
+			{"one", 10, EvictCleared},
+		}
+		if len(*evictions) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, *evictions)
+		}
+		for i, e := range expected {
+			if (*evictions)[i] != e {
+				t.Errorf("eviction %d: expected %v, got %v", i, e, (*evictions)[i])
+			}
+		}
+	})
+
+	t.Run("capacity", func(t *testing.T) {
+		cache, evictions := newCache(0)
+		cache.WithMaxEntries(1)
+
+		cache.Set("one", 1)
+		cache.Set("two", 2)
+
+		if len(*evictions) != 1 || (*evictions)[0] != (eviction{"one", 1, EvictCapacity}) {
+			t.Errorf("expected capacity eviction of 'one', got %v", *evictions)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		cache, evictions := newCache(20 * time.Millisecond)
+
+		cache.Set("one", 1)
+		cache.Set("two", 2)
+		time.Sleep(40 * time.Millisecond)
+
+		// Both purging and overwriting an expired value report expiry
+		cache.Set("two", 20)
+		cache.Purge()
+
+		if len(*evictions) != 2 {
+			t.Fatalf("expected 2 evictions, got %v", *evictions)
+		}
+		for _, e := range *evictions {
+			if e.reason != EvictExpired {
+				t.Errorf("expected expired reason, got %v", e)
+			}
+		}
+	})
+
+	t.Run("handler may use the cache", func(t *testing.T) {
+		var cache *Cache[string, int]
+		cache = NewCache[string, int](0, 0, nil, nil).
+			WithMaxEntries(1).
+			WithEvictHandler(func(key string, value int, reason EvictReason) {
+				cache.Has(key)
+			})
+
+		cache.Set("one", 1)
+		cache.Set("two", 2)
+		cache.Delete("two")
+	})
+}
+
+func TestCacheStats(t *testing.T) {
+	t.Run("Cache", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			if key == "error" {
+				return 0, errors.New("test error")
+			}
+			return len(key), nil
+		}).WithMaxEntries(2)
+
+		cache.GetDefault("one")   // miss + load
+		cache.GetDefault("one")   // hit
+		cache.GetNoDefault("two") // miss
+		cache.GetDefault("error") // miss + failed load
+		cache.Set("two", 2)
+		cache.Set("three", 3) // capacity eviction
+		cache.Has("three")    // not counted
+
+		stats := cache.Stats()
+		if stats.Hits != 1 || stats.Misses != 3 {
+			t.Errorf("expected 1 hit and 3 misses, got %+v", stats)
+		}
+		if stats.LoadSuccesses != 1 || stats.LoadFailures != 1 {
+			t.Errorf("expected 1 successful and 1 failed load, got %+v", stats)
+		}
+		if stats.Evictions != 1 || stats.Size != 2 {
+			t.Errorf("expected 1 eviction and size 2, got %+v", stats)
+		}
+		if stats.HitRatio() != 0.25 {
+			t.Errorf("expected hit ratio 0.25, got %v", stats.HitRatio())
+		}
+	})
+
+	t.Run("load time", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			time.Sleep(20 * time.Millisecond)
+			return 1, nil
+		})
+		cache.GetDefault("key")
+
+		if stats := cache.Stats(); stats.TotalLoadTime < 20*time.Millisecond || stats.AverageLoadTime() != stats.TotalLoadTime {
+			t.Errorf("expected load time of at least 20ms, got %+v", stats)
+		}
+	})
+
+	t.Run("CacheValue", func(t *testing.T) {
+		cv := NewCacheValue[int](0, func() (int, error) {
+			return 42, nil
+		})
+
+		cv.GetDefault()
+		cv.GetDefault()
+		cv.Has()
+
+		stats := cv.Stats()
+		if stats.Hits != 1 || stats.Misses != 1 || stats.LoadSuccesses != 1 || stats.Size != 1 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	})
+}
+
+func TestShardedCache(t *testing.T) {
+	newShard := func() *Cache[int, int] {
+		return NewCache[int, int](0, 0, nil, func(key int) (int, error) {
+			return key * 10, nil
+		})
+	}
+
+	t.Run("basic operations", func(t *testing.T) {
+		cache := NewShardedCache[int, int](4, nil, newShard)
+
+		for i := 0; i < 100; i++ {
+			cache.Set(i, i)
+		}
+		if cache.Len() != 100 || len(cache.Keys()) != 100 || len(cache.Values()) != 100 {
+			t.Errorf("expected 100 entries, got %d", cache.Len())
+		}
+
+		used := 0
+		for _, shard := range cache.shards {
+			if shard.Len() > 0 {
+				used++
+			}
+		}
+		if used != 4 {
+			t.Errorf("expected keys spread over 4 shards, got %d", used)
+		}
+
+		if val, ok := cache.GetNoDefault(42); !ok || val != 42 {
+			t.Errorf("expected 42, got %v, %v", val, ok)
+		}
+		if val, ok, err := cache.GetDefault(1000); err != nil || ok || val != 10000 {
+			t.Errorf("expected loaded 10000, got %v, %v, %v", val, ok, err)
+		}
+
+		if !cache.Delete(42) || cache.Has(42) {
+			t.Error("expected 42 to be deleted")
+		}
+
+		count := 0
+		cache.Range(func(k, v int) bool {
+			count++
+			return count < 10
+		})
+		if count != 10 {
+			t.Errorf("expected range to stop at 10, got %d", count)
+		}
+
+		values, errs := cache.GetMany([]int{1, 2, 5000})
+		if errs != nil || values[1] != 1 || values[5000] != 50000 {
+			t.Errorf("unexpected GetMany result: %v, %v", values, errs)
+		}
+
+		if stats := cache.Stats(); stats.Size != 101 || stats.LoadSuccesses != 2 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+
+		cache.Clear()
+		if cache.Len() != 0 {
+			t.Errorf("expected empty cache, got %d", cache.Len())
+		}
+	})
+
+	t.Run("custom hasher", func(t *testing.T) {
+		cache := NewShardedCache[int, int](2, func(k int) uint64 { return uint64(k) }, newShard)
+
+		cache.Set(1, 1)
+		cache.Set(2, 2)
+		if !cache.shards[1].Has(1) || !cache.shards[0].Has(2) {
+			t.Error("expected keys placed by the custom hasher")
+		}
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		cache := NewShardedCache[int, int](8, nil, newShard)
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 200; i++ {
+					key := g*1000 + i
+					cache.Set(key, i)
+					cache.Get(key)
+					cache.Has(key + 1)
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if cache.Len() != 1600 {
+			t.Errorf("expected 1600 entries, got %d", cache.Len())
+		}
+	})
+
+	t.Run("tags span shards", func(t *testing.T) {
+		cache := NewShardedCache[int, int](4, nil, newShard)
+		for i := 0; i < 20; i++ {
+			tag := "even"
+			if i%2 == 1 {
+				tag = "odd"
+			}
+			cache.SetWithTags(i, i, tag, "all")
+		}
+
+		if n := cache.InvalidateTag("odd"); n != 10 {
+			t.Errorf("expected 10 invalidated entries, got %d", n)
+		}
+		if cache.Len() != 10 || cache.Has(1) || !cache.Has(2) {
+			t.Errorf("expected only even keys to remain, got %v", cache.Keys())
+		}
+		if n := cache.InvalidateTag("all"); n != 10 || cache.Len() != 0 {
+			t.Errorf("expected all remaining entries to be invalidated, got %d", n)
+		}
+	})
+
+	t.Run("subscribe merges shard events", func(t *testing.T) {
+		cache := NewShardedCache[int, int](4, nil, newShard)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events := cache.Subscribe(ctx)
+
+		for i := 0; i < 20; i++ {
+			cache.Set(i, i)
+		}
+		seen := make(Set[int])
+		for seen.Len() < 20 {
+			select {
+			case e := <-events:
+				if e.Type != CacheEventSet || e.NewValue != e.Key {
+					t.Errorf("unexpected event %+v", e)
+				}
+				seen.Add(e.Key)
+			case <-time.After(time.Second):
+				t.Fatalf("expected 20 set events, got %d", seen.Len())
+			}
+		}
+		if cache.DroppedEvents() != 0 {
+			t.Errorf("expected no dropped events, got %d", cache.DroppedEvents())
+		}
+
+		cancel()
+		select {
+		case _, ok := <-events:
+			if ok {
+				t.Error("expected no more events")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected channel to be closed")
+		}
+	})
+
+	t.Run("snapshot and restore", func(t *testing.T) {
+		cache := NewShardedCache[int, int](4, nil, newShard)
+		for i := 0; i < 20; i++ {
+			cache.Set(i, i)
+		}
+
+		var buf bytes.Buffer
+		if err := cache.Snapshot(&buf); err != nil {
+			t.Fatalf("unexpected snapshot error: %v", err)
+		}
+
+		restored := NewShardedCache[int, int](3, nil, newShard)
+		if err := restored.Restore(&buf); err != nil {
+			t.Fatalf("unexpected restore error: %v", err)
+		}
+		if restored.Len() != 20 {
+			t.Errorf("expected 20 restored entries, got %d", restored.Len())
+		}
+		for i := 0; i < 20; i++ {
+			if val, ok := restored.Shard(i).GetNoDefault(i); !ok || val != i {
+				t.Errorf("expected %d in its shard, got %v, %v", i, val, ok)
+			}
+		}
+	})
+
+	t.Run("restore counts time since snapshot", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		newClockShard := func() *Cache[int, int] {
+			return NewCache[int, int](0, 0, nil, nil).WithClock(clock)
+		}
+
+		cache := NewShardedCache[int, int](4, nil, newClockShard)
+		cache.SetWithTTL(1, 1, time.Minute)
+		cache.SetWithTTL(2, 2, time.Hour)
+
+		var buf bytes.Buffer
+		if err := cache.Snapshot(&buf); err != nil {
+			t.Fatalf("unexpected snapshot error: %v", err)
+		}
+		clock.Advance(30 * time.Minute)
+
+		restored := NewShardedCache[int, int](3, nil, newClockShard)
+		if err := restored.Restore(&buf); err != nil {
+			t.Fatalf("unexpected restore error: %v", err)
+		}
+		if restored.Len() != 1 || restored.Has(1) {
+			t.Errorf("expected only 2 to be restored, got %d entries", restored.Len())
+		}
+		clock.Advance(31 * time.Minute)
+		if restored.Has(2) {
+			t.Error("expected 2 to expire an hour after it was set")
+		}
+	})
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	type User struct {
+		ID   string
+		Name string
+	}
+
+	for name, codec := range map[string]CacheCodec{"gob": GobCodec, "json": JSONCodec} {
+		t.Run(name, func(t *testing.T) {
+			src := NewCache[string, User](time.Hour, 0, nil, nil).WithCodec(codec)
+			src.Set("1", User{ID: "1", Name: "John"})
+			src.SetWithTTL("2", User{ID: "2", Name: "Jane"}, 0)
+			src.SetWithTTL("3", User{ID: "3", Name: "Expired"}, time.Millisecond)
+			time.Sleep(5 * time.Millisecond)
+
+			var buf bytes.Buffer
+			if err := src.Snapshot(&buf); err != nil {
+				t.Fatalf("snapshot: %v", err)
+			}
+
+			dst := NewCache[string, User](0, 0, nil, nil).WithCodec(codec)
+			if err := dst.Restore(&buf); err != nil {
+				t.Fatalf("restore: %v", err)
+			}
+
+			if dst.Len() != 2 {
+				t.Errorf("expected 2 restored entries, got %d", dst.Len())
+			}
+			if val, ok := dst.GetNoDefault("1"); !ok || val.Name != "John" {
+				t.Errorf("expected John, got %v, %v", val, ok)
+			}
+
+			// Remaining TTLs are preserved
+			_, expiresAt, _ := dst.storage["1"].entry()
+			if remaining := time.Until(expiresAt); remaining <= 59*time.Minute || remaining > time.Hour {
+				t.Errorf("expected about an hour left, got %v", remaining)
+			}
+			if _, expiresAt, _ := dst.storage["2"].entry(); !expiresAt.IsZero() {
+				t.Errorf("expected no expiration, got %v", expiresAt)
+			}
+		})
+	}
+
+	t.Run("restore counts time since snapshot", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		src := NewCache[string, int](0, 0, nil, nil).WithClock(clock)
+		src.SetWithTTL("short", 1, time.Minute)
+		src.SetWithTTL("long", 2, time.Hour)
+		src.Set("forever", 3)
+
+		var buf bytes.Buffer
+		if err := src.Snapshot(&buf); err != nil {
+			t.Fatalf("snapshot: %v", err)
+		}
+		clock.Advance(30 * time.Minute)
+
+		dst := NewCache[string, int](0, 0, nil, nil).WithClock(clock)
+		if err := dst.Restore(&buf); err != nil {
+			t.Fatalf("restore: %v", err)
+		}
+		if dst.Len() != 2 || dst.Has("short") {
+			t.Errorf("expected short to be skipped, got %d entries", dst.Len())
+		}
+		if _, expiresAt, _ := dst.storage["long"].entry(); !expiresAt.Equal(clock.Now().Add(30 * time.Minute)) {
+			t.Errorf("expected long to have 30m left, got %v", expiresAt.Sub(clock.Now()))
+		}
+		if _, expiresAt, _ := dst.storage["forever"].entry(); !expiresAt.IsZero() {
+			t.Errorf("expected no expiration, got %v", expiresAt)
+		}
+	})
+
+	t.Run("invalid input", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil).WithCodec(JSONCodec)
+		if err := cache.Restore(strings.NewReader("not json")); err == nil {
+			t.Error("expected decode error")
+		}
+	})
+}
+
+func TestCacheTags(t *testing.T) {
+	t.Run("InvalidateTag", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil)
+
+		cache.SetWithTags("a1", 1, "tenant:a")
+		cache.SetWithTags("a2", 2, "tenant:a", "hot")
+		cache.SetWithTags("b1", 3, "tenant:b", "hot")
+		cache.Set("plain", 4)
+
+		if removed := cache.InvalidateTag("tenant:a"); removed != 2 {
+			t.Errorf("expected 2 removed entries, got %d", removed)
+		}
+		if cache.Has("a1") || cache.Has("a2") {
+			t.Error("expected tenant:a entries to be removed")
+		}
+		if !cache.Has("b1") || !cache.Has("plain") {
+			t.Error("expected other entries to remain")
+		}
+
+		// a2 no longer carries "hot"
+		if removed := cache.InvalidateTag("hot"); removed != 1 {
+			t.Errorf("expected 1 removed entry, got %d", removed)
+		}
+		if removed := cache.InvalidateTag("unknown"); removed != 0 {
+			t.Errorf("expected nothing removed, got %d", removed)
+		}
+	})
+
+	t.Run("overwrite replaces tags", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil)
+
+		cache.SetWithTags("key", 1, "old")
+		cache.Set("key", 2)
+
+		if removed := cache.InvalidateTag("old"); removed != 0 {
+			t.Errorf("expected no entries tagged 'old', got %d", removed)
+		}
+		if !cache.Has("key") {
+			t.Error("expected key to remain")
+		}
+		if len(cache.tagIndex) != 0 || len(cache.keyTags) != 0 {
+			t.Errorf("expected empty tag index, got %v, %v", cache.tagIndex, cache.keyTags)
+		}
+	})
+
+	t.Run("tags from default function", func(t *testing.T) {
+		type Order struct {
+			ID     string
+			Tenant string
+		}
+		cache := NewCache[string, Order](0, 0, nil, func(id string) (Order, error) {
+			return Order{ID: id, Tenant: "t" + id[:1]}, nil
+		}).WithTagFn(func(key string, value Order) []string {
+			return []string{value.Tenant}
+		})
+
+		cache.Get("1a")
+		cache.Get("1b")
+		cache.Get("2a")
+
+		if removed := cache.InvalidateTag("t1"); removed != 2 {
+			t.Errorf("expected 2 removed entries, got %d", removed)
+		}
+		if !cache.Has("2a") {
+			t.Error("expected '2a' to remain")
+		}
+	})
+
+	t.Run("removed entries are untagged", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil).WithMaxEntries(1)
+
+		cache.SetWithTags("one", 1, "tag")
+		cache.SetWithTags("two", 2, "tag")
+		cache.Delete("two")
+
+		if len(cache.tagIndex) != 0 || len(cache.keyTags) != 0 {
+			t.Errorf("expected empty tag index, got %v, %v", cache.tagIndex, cache.keyTags)
+		}
+	})
+}
+
+func TestCacheClock(t *testing.T) {
+	t.Run("expiration without sleeping", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache := NewCache[string, int](time.Minute, 0, nil, nil).WithClock(clock)
+
+		cache.Set("key", 1)
+		clock.Advance(59 * time.Second)
+		if !cache.Has("key") {
+			t.Error("expected key before timeout")
+		}
+		clock.Advance(2 * time.Second)
+		if cache.Has("key") {
+			t.Error("expected key to be expired")
+		}
+	})
+
+	t.Run("CacheValue", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cv := NewCacheValue[int](time.Minute, nil).WithClock(clock)
+
+		cv.Set(1)
+		clock.Advance(2 * time.Minute)
+		if cv.Has() {
+			t.Error("expected value to be expired")
+		}
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache := NewCache[string, int](time.Minute, 0, nil, nil).WithClock(clock)
+		cache.StartJanitor(context.Background(), time.Minute)
+		defer cache.StopJanitor()
+
+		cache.Set("key", 1)
+		clock.BlockUntil(1)
+		clock.Advance(2 * time.Minute)
+
+		deadline := time.Now().Add(time.Second)
+		for cache.Stats().Size != 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("expected janitor to purge the expired entry")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestCacheSlidingExpiration(t *testing.T) {
+	t.Run("reads extend expiry", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache := NewCache[string, int](time.Minute, 0, nil, nil).
+			WithClock(clock).
+			WithSlidingExpiration(0)
+
+		cache.Set("session", 1)
+		for i := 0; i < 5; i++ {
+			clock.Advance(50 * time.Second)
+			if _, ok := cache.GetNoDefault("session"); !ok {
+				t.Fatalf("expected session to be kept alive by reads (iteration %d)", i)
+			}
+		}
+
+		// Has does not count as activity
+		clock.Advance(50 * time.Second)
+		cache.Has("session")
+		clock.Advance(20 * time.Second)
+		if cache.Has("session") {
+			t.Error("expected session to expire after inactivity")
+		}
+	})
+
+	t.Run("capped by max lifetime", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache := NewCache[string, int](time.Minute, 0, nil, func(key string) (int, error) {
+			return 1, nil
+		}).WithClock(clock).WithSlidingExpiration(2 * time.Minute)
+
+		cache.GetDefault("session")
+		for i := 0; i < 3; i++ {
+			clock.Advance(40 * time.Second)
+			cache.GetDefault("session")
+		}
+		// 2m since set: the lifetime cap is reached despite recent reads
+		clock.Advance(time.Second)
+		if cache.Has("session") {
+			t.Error("expected session to expire at max lifetime")
+		}
+	})
+
+	t.Run("entries without TTL are unaffected", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithClock(clock).
+			WithSlidingExpiration(time.Minute)
+
+		cache.Set("key", 1)
+		cache.GetNoDefault("key")
+		clock.Advance(time.Hour)
+		if !cache.Has("key") {
+			t.Error("expected key without TTL to remain")
+		}
+	})
+}
+
+func TestCacheMaxCost(t *testing.T) {
+	size := func(key string, value []byte) int64 {
+		return int64(len(value))
+	}
+
+	t.Run("evicts until within budget", func(t *testing.T) {
+		cache := NewCache[string, []byte](0, 0, nil, nil).WithMaxCost(10, size)
+
+		cache.Set("a", make([]byte, 4))
+		cache.Set("b", make([]byte, 4))
+		if cache.TotalCost() != 8 {
+			t.Errorf("expected total cost 8, got %d", cache.TotalCost())
+		}
+
+		cache.GetNoDefault("a")
+		cache.Set("c", make([]byte, 6))
+		if cache.Has("b") {
+			t.Error("expected least recently used b to be evicted")
+		}
+		if !cache.Has("a") || !cache.Has("c") {
+			t.Error("expected a and c to remain")
+		}
+		if cache.TotalCost() != 10 {
+			t.Errorf("expected total cost 10, got %d", cache.TotalCost())
+		}
+	})
+
+	t.Run("overwrite and delete adjust cost", func(t *testing.T) {
+		cache := NewCache[string, []byte](0, 0, nil, nil).WithMaxCost(100, size)
+
+		cache.Set("a", make([]byte, 10))
+		cache.Set("a", make([]byte, 3))
+		if cache.TotalCost() != 3 {
+			t.Errorf("expected total cost 3 after overwrite, got %d", cache.TotalCost())
+		}
+
+		cache.Delete("a")
+		if cache.TotalCost() != 0 {
+			t.Errorf("expected total cost 0 after delete, got %d", cache.TotalCost())
+		}
+	})
+
+	t.Run("oversized value is not kept", func(t *testing.T) {
+		var reasons []EvictReason
+		cache := NewCache[string, []byte](0, 0, nil, nil).
+			WithMaxCost(10, size).
+			WithEvictHandler(func(key string, value []byte, reason EvictReason) {
+				reasons = append(reasons, reason)
+			})
+
+		cache.Set("small", make([]byte, 2))
+		cache.Set("huge", make([]byte, 20))
+		if cache.Len() != 0 || cache.TotalCost() != 0 {
+			t.Errorf("expected empty cache, got %d entries costing %d", cache.Len(), cache.TotalCost())
+		}
+		if len(reasons) != 2 || reasons[0] != EvictCapacity || reasons[1] != EvictCapacity {
+			t.Errorf("expected two capacity evictions, got %v", reasons)
+		}
+	})
+
+	t.Run("loaded values are charged", func(t *testing.T) {
+		cache := NewCache[string, []byte](0, 0, nil, func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}).WithMaxCost(8, size)
+
+		for _, k := range []string{"one", "two", "three"} {
+			if _, _, err := cache.GetDefault(k); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if cache.TotalCost() > 8 {
+			t.Errorf("expected total cost within 8, got %d", cache.TotalCost())
+		}
+		if cache.Has("one") {
+			t.Error("expected one to be evicted")
+		}
+	})
+}
+
+// failingSecondaryStore is a SecondaryStore whose every call fails
+type failingSecondaryStore[K comparable, V any] struct {
+	err error
+}
+
+func (s failingSecondaryStore[K, V]) Get(context.Context, K) (V, error) {
+	var zero V
+	return zero, s.err
+}
+
+func (s failingSecondaryStore[K, V]) Set(context.Context, K, V, time.Duration) error {
+	return s.err
+}
+
+func (s failingSecondaryStore[K, V]) Delete(context.Context, K) error {
+	return s.err
+}
+
+func TestCacheSecondaryStore(t *testing.T) {
+	t.Run("misses are served from the secondary store", func(t *testing.T) {
+		remote := NewLocalSecondaryStore[string, int](nil)
+		remote.Cache().Set("key", 42)
+
+		var calls atomic.Int32
+		cache := NewCache[string, int](time.Minute, 0, nil, func(key string) (int, error) {
+			calls.Add(1)
+			return 1, nil
+		}).WithSecondaryStore(remote, SecondaryWriteThrough)
+
+		val, ok, err := cache.GetDefault("key")
+		if err != nil || ok || val != 42 {
+			t.Errorf("expected (42, false, nil), got (%d, %v, %v)", val, ok, err)
+		}
+		if calls.Load() != 0 {
+			t.Errorf("expected defaultFn not to be called, got %d calls", calls.Load())
+		}
+		if val, ok := cache.GetNoDefault("key"); !ok || val != 42 {
+			t.Errorf("expected value to be cached locally, got (%d, %v)", val, ok)
+		}
+
+		val, _, err = cache.GetDefault("other")
+		if err != nil || val != 1 {
+			t.Errorf("expected defaultFn value 1, got (%d, %v)", val, err)
+		}
+		if val, ok := remote.Cache().GetNoDefault("other"); !ok || val != 1 {
+			t.Errorf("expected loaded value to be written to the secondary store, got (%d, %v)", val, ok)
+		}
+	})
+
+	t.Run("write-through", func(t *testing.T) {
+		remote := NewLocalSecondaryStore[string, int](nil)
+		cache := NewCache[string, int](0, 0, nil, nil).WithSecondaryStore(remote, SecondaryWriteThrough)
+
+		cache.Set("key", 1)
+		if val, ok := remote.Cache().GetNoDefault("key"); !ok || val != 1 {
+			t.Errorf("expected key in the secondary store, got (%d, %v)", val, ok)
+		}
+
+		cache.Delete("key")
+		if remote.Cache().Has("key") {
+			t.Error("expected key to be deleted from the secondary store")
+		}
+
+		cache.Set("kept", 1)
+		cache.Clear()
+		if !remote.Cache().Has("kept") {
+			t.Error("expected Clear to affect the local cache only")
+		}
+		if val, ok, err := cache.Get("kept"); err != nil || !ok || val != 1 {
+			t.Errorf("expected kept to be reloaded from the secondary store, got (%d, %v, %v)", val, ok, err)
+		}
+		if _, _, err := cache.Get("missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("write-behind", func(t *testing.T) {
+		remote := NewLocalSecondaryStore[string, int](nil)
+		cache := NewCache[string, int](0, 0, nil, nil).WithSecondaryStore(remote, SecondaryWriteBehind)
+
+		for i := 0; i < 100; i++ {
+			cache.Set(fmt.Sprintf("key%d", i), i)
+		}
+		cache.Set("key0", -1)
+		cache.Delete("key1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := cache.FlushSecondary(ctx); err != nil {
+			t.Fatalf("unexpected flush error: %v", err)
+		}
+
+		if remote.Cache().Len() != 99 {
+			t.Errorf("expected 99 keys in the secondary store, got %d", remote.Cache().Len())
+		}
+		if val, _ := remote.Cache().GetNoDefault("key0"); val != -1 {
+			t.Errorf("expected the latest write to win, got %d", val)
+		}
+		if remote.Cache().Has("key1") {
+			t.Error("expected key1 to be deleted from the secondary store")
+		}
+	})
+
+	t.Run("errors are reported and treated as misses", func(t *testing.T) {
+		failure := errors.New("unavailable")
+		var mu sync.Mutex
+		var reported []error
+		cache := NewCache[string, int](0, 0, nil, func(key string) (int, error) {
+			return 7, nil
+		}).
+			WithSecondaryStore(failingSecondaryStore[string, int]{err: failure}, SecondaryWriteThrough).
+			WithSecondaryErrorHandler(func(key string, err error) {
+				mu.Lock()
+				reported = append(reported, err)
+				mu.Unlock()
+			})
+
+		val, _, err := cache.GetDefault("key")
+		if err != nil || val != 7 {
+			t.Errorf("expected fallback to defaultFn, got (%d, %v)", val, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		// One failed lookup and one failed write of the loaded value
+		if len(reported) != 2 || !errors.Is(reported[0], failure) {
+			t.Errorf("expected 2 reported errors, got %v", reported)
+		}
+	})
+}
+
+func TestCacheSubscribe(t *testing.T) {
+	receive := func(t *testing.T, events <-chan CacheEvent[string, int]) CacheEvent[string, int] {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+			return CacheEvent[string, int]{}
+		}
+	}
+
+	t.Run("reports changes", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache := NewCache[string, int](time.Minute, 0, nil, nil).WithClock(clock).WithMaxEntries(2)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := cache.Subscribe(ctx)
+
+		cache.Set("a", 1)
+		if e := receive(t, events); e.Type != CacheEventSet || e.Key != "a" || e.HasOld || e.NewValue != 1 {
+			t.Errorf("unexpected event %+v", e)
+		}
+
+		cache.Set("a", 2)
+		if e := receive(t, events); e.Type != CacheEventSet || !e.HasOld || e.OldValue != 1 || e.NewValue != 2 {
+			t.Errorf("unexpected event %+v", e)
+		}
+
+		cache.Delete("a")
+		if e := receive(t, events); e.Type != CacheEventDelete || e.OldValue != 2 {
+			t.Errorf("unexpected event %+v", e)
+		}
+
+		cache.Set("b", 1)
+		cache.Set("c", 1)
+		cache.Set("d", 1)
+		receive(t, events)
+		receive(t, events)
+		if e := receive(t, events); e.Type != CacheEventEvict || e.Key != "b" {
+			t.Errorf("expected eviction of b, got %+v", e)
+		}
+		receive(t, events)
+
+		clock.Advance(2 * time.Minute)
+		cache.Purge()
+		for i := 0; i < 2; i++ {
+			if e := receive(t, events); e.Type != CacheEventExpire {
+				t.Errorf("expected expire event, got %+v", e)
+			}
+		}
+	})
+
+	t.Run("drops newest events for slow consumers", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil).WithEventBuffer(2)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := cache.Subscribe(ctx)
+
+		for i := 0; i < 5; i++ {
+			cache.Set("key", i)
+		}
+		if cache.DroppedEvents() != 3 {
+			t.Errorf("expected 3 dropped events, got %d", cache.DroppedEvents())
+		}
+		if e := receive(t, events); e.NewValue != 0 {
+			t.Errorf("expected the oldest event to be kept, got %+v", e)
+		}
+	})
+
+	t.Run("closes channel when ctx is done", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events := cache.Subscribe(ctx)
+		cancel()
+
+		select {
+		case _, ok := <-events:
+			if ok {
+				t.Error("expected no events")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected channel to be closed")
+		}
+		cache.Set("key", 1)
+	})
+}
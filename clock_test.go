@@ -0,0 +1,77 @@
+package notstd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Now and Advance", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		clock.Advance(time.Hour)
+		if got := clock.Now(); !got.Equal(start.Add(time.Hour)) {
+			t.Errorf("expected %v, got %v", start.Add(time.Hour), got)
+		}
+	})
+
+	t.Run("After", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		ch := clock.After(time.Minute)
+
+		clock.Advance(30 * time.Second)
+		select {
+		case <-ch:
+			t.Fatal("fired too early")
+		default:
+		}
+
+		clock.Advance(30 * time.Second)
+		select {
+		case at := <-ch:
+			if !at.Equal(start.Add(time.Minute)) {
+				t.Errorf("expected %v, got %v", start.Add(time.Minute), at)
+			}
+		default:
+			t.Fatal("expected After to fire")
+		}
+	})
+
+	t.Run("Ticker", func(t *testing.T) {
+		clock := NewFakeClock(start)
+		ticker := clock.NewTicker(time.Second)
+
+		clock.Advance(500 * time.Millisecond)
+		select {
+		case <-ticker.C():
+			t.Fatal("ticked too early")
+		default:
+		}
+
+		// Several periods at once produce a single tick, like time.Ticker
+		clock.Advance(3 * time.Second)
+		<-ticker.C()
+		select {
+		case <-ticker.C():
+			t.Fatal("expected dropped ticks")
+		default:
+		}
+
+		ticker.Reset(time.Minute)
+		clock.Advance(time.Second)
+		select {
+		case <-ticker.C():
+			t.Fatal("expected reset to postpone the tick")
+		default:
+		}
+
+		ticker.Stop()
+		clock.Advance(time.Hour)
+		select {
+		case <-ticker.C():
+			t.Fatal("expected stopped ticker not to tick")
+		default:
+		}
+	})
+}
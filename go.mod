@@ -1,3 +1,3 @@
 module github.com/bomjdev/notstd
 
-go 1.18
+go 1.24
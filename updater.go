@@ -2,6 +2,7 @@ package notstd
 
 import (
 	"context"
+	"reflect"
 	"sync"
 	"time"
 )
@@ -21,6 +22,49 @@ type Sink[K comparable, V any] interface {
 	Apply(ctx context.Context, data map[K]V) error
 }
 
+// Delta - инкрементальное обновление: новые и измененные значения плюс явно удаленные ключи.
+// Позволяет передавать удаления без специальных значений-маркеров.
+type Delta[K comparable, V any] struct {
+	// Upserts - добавленные и измененные значения.
+	Upserts map[K]V
+	// Deleted - ключи, которые нужно удалить.
+	Deleted []K
+}
+
+// DeltaSink - приемник, который умеет применять Delta.
+type DeltaSink[K comparable, V any] interface {
+	// ApplyDelta применяет инкрементальное обновление к хранилищу.
+	ApplyDelta(ctx context.Context, delta Delta[K, V]) error
+}
+
+// Changes - отличия содержимого хранилища до и после применения обновления.
+type Changes[K comparable, V any] struct {
+	// Added - новые ключи и их значения.
+	Added map[K]V
+	// Updated - ключи, значения которых изменились.
+	Updated map[K]ValueChange[V]
+	// Removed - удаленные ключи и их последние значения.
+	Removed map[K]V
+}
+
+// ValueChange - старое и новое значение измененного ключа.
+type ValueChange[V any] struct {
+	Old V
+	New V
+}
+
+// Empty сообщает, что обновление ничего не изменило.
+func (c Changes[K, V]) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+// ChangeSink - приемник, который сообщает, что изменилось при применении обновления.
+// Если приемник Updater реализует ChangeSink, обработчик WithChangeHandler получает эти изменения.
+type ChangeSink[K comparable, V any] interface {
+	// ApplyChanges применяет Delta так же, как ApplyDelta, и возвращает изменения.
+	ApplyChanges(ctx context.Context, delta Delta[K, V]) (Changes[K, V], error)
+}
+
 // UpdateStrategy определяет стратегию применения обновлений к хранилищу.
 type UpdateStrategy int
 
@@ -41,7 +85,8 @@ const (
 	StrategyUpsertOnly
 
 	// StrategyIncremental - инкрементальное обновление с явной обработкой удалений.
-	// Требует чтобы источник возвращал информацию о том, какие ключи нужно удалить.
+	// Удаляет ключи из Delta.Deleted, поэтому источник должен возвращать Delta (см. NewDeltaUpdater).
+	// Через Apply удаления не передаются, и стратегия работает как StrategyMerge.
 	// Использование: когда источник возвращает дельту (добавления, обновления, удаления).
 	StrategyIncremental
 )
@@ -50,6 +95,7 @@ const (
 type StoreSink[K comparable, V any] struct {
 	store    *Store[K, V]
 	strategy UpdateStrategy
+	equal    EqualFn[V]
 }
 
 // NewStoreSink создает новый StoreSink с указанной стратегией обновления.
@@ -60,40 +106,168 @@ func NewStoreSink[K comparable, V any](store *Store[K, V], strategy UpdateStrate
 	}
 }
 
+// WithEqualFn устанавливает функцию сравнения значений для ApplyChanges
+// (по умолчанию reflect.DeepEqual).
+func (s *StoreSink[K, V]) WithEqualFn(equal EqualFn[V]) *StoreSink[K, V] {
+	s.equal = equal
+	return s
+}
+
 // Apply применяет данные к Store согласно выбранной стратегии.
 func (s *StoreSink[K, V]) Apply(ctx context.Context, data map[K]V) error {
+	return s.ApplyDelta(ctx, Delta[K, V]{Upserts: data})
+}
+
+// ApplyDelta применяет Delta к Store согласно выбранной стратегии.
+// Delta.Deleted учитывается только стратегией StrategyIncremental,
+// для StrategyReplace новым содержимым Store становится Delta.Upserts.
+func (s *StoreSink[K, V]) ApplyDelta(ctx context.Context, delta Delta[K, V]) error {
+	s.applyDelta(delta, false)
+	return nil
+}
+
+// ApplyChanges применяет Delta как ApplyDelta и возвращает отличия от прежнего содержимого Store.
+// Значения сравниваются функцией из WithEqualFn.
+func (s *StoreSink[K, V]) ApplyChanges(ctx context.Context, delta Delta[K, V]) (Changes[K, V], error) {
+	return s.applyDelta(delta, true), nil
+}
+
+// applyDelta применяет Delta и, если track, вычисляет изменения
+// по прежним значениям затронутых ключей.
+func (s *StoreSink[K, V]) applyDelta(delta Delta[K, V], track bool) Changes[K, V] {
 	s.store.Lock()
 	defer s.store.Unlock()
 
+	var before map[K]V
+	if track && s.strategy != StrategyReplace {
+		before = make(map[K]V)
+		for k := range delta.Upserts {
+			if v, ok := s.store.m[k]; ok {
+				before[k] = v
+			}
+		}
+		if s.strategy == StrategyIncremental {
+			for _, k := range delta.Deleted {
+				if v, ok := s.store.m[k]; ok {
+					before[k] = v
+				}
+			}
+		}
+	}
+
 	switch s.strategy {
 	case StrategyReplace:
 		// Полная замена: очищаем Store и записываем новые данные
-		s.store.m = make(map[K]V, len(data))
-		for k, v := range data {
+		before = s.store.m
+		s.store.m = make(map[K]V, len(delta.Upserts))
+		for k, v := range delta.Upserts {
 			s.store.m[k] = v
 		}
 
 	case StrategyMerge, StrategyUpsertOnly:
 		// Merge/Upsert: обновляем существующие и добавляем новые
-		for k, v := range data {
+		for k, v := range delta.Upserts {
 			s.store.m[k] = v
 		}
 
 	case StrategyIncremental:
-		// Для инкрементальных обновлений используется та же логика что и Merge
-		// Специальная обработка удалений должна быть на уровне Source
-		// (например, Source может передавать zero-value для удаления)
-		for k, v := range data {
+		// Инкрементальное обновление: сначала удаления, затем вставки,
+		// чтобы ключ, присутствующий в обоих списках, остался с новым значением
+		for _, k := range delta.Deleted {
+			delete(s.store.m, k)
+		}
+		for k, v := range delta.Upserts {
 			s.store.m[k] = v
 		}
 	}
 
-	return nil
+	if !track {
+		return Changes[K, V]{}
+	}
+	return s.diff(before, delta.Upserts)
+}
+
+// diff сравнивает прежние значения затронутых ключей с текущим содержимым Store.
+// before - прежние значения, upserts - ключи, которые могли быть добавлены.
+func (s *StoreSink[K, V]) diff(before, upserts map[K]V) Changes[K, V] {
+	equal := s.equal
+	if equal == nil {
+		equal = func(a, b V) bool { return reflect.DeepEqual(a, b) }
+	}
+
+	changes := Changes[K, V]{
+		Added:   make(map[K]V),
+		Updated: make(map[K]ValueChange[V]),
+		Removed: make(map[K]V),
+	}
+	for k, old := range before {
+		cur, ok := s.store.m[k]
+		switch {
+		case !ok:
+			changes.Removed[k] = old
+		case !equal(old, cur):
+			changes.Updated[k] = ValueChange[V]{Old: old, New: cur}
+		}
+	}
+	for k := range upserts {
+		if _, existed := before[k]; !existed {
+			changes.Added[k] = s.store.m[k]
+		}
+	}
+	return changes
+}
+
+// CacheSink - реализация Sink для Cache.
+// Записывает значения в Cache с заданным TTL, поэтому данные истекают,
+// если Updater перестал их обновлять.
+type CacheSink[K comparable, V any] struct {
+	cache    *Cache[K, V]
+	strategy UpdateStrategy
+	ttl      time.Duration
+}
+
+// NewCacheSink создает новый CacheSink с указанной стратегией обновления.
+// ttl - время жизни записанных значений (CacheDefaultTTL - timeout кэша, 0 - без истечения).
+func NewCacheSink[K comparable, V any](cache *Cache[K, V], strategy UpdateStrategy, ttl time.Duration) *CacheSink[K, V] {
+	return &CacheSink[K, V]{
+		cache:    cache,
+		strategy: strategy,
+		ttl:      ttl,
+	}
 }
 
-//// Middleware - функция-обертка для добавления дополнительной логики к Fetch.
-//// Примеры: retry, logging, validation, caching.
-//type Middleware[T any] func(next FetchFunc[T]) FetchFunc[T]
+// Apply применяет данные к Cache согласно выбранной стратегии.
+func (s *CacheSink[K, V]) Apply(ctx context.Context, data map[K]V) error {
+	return s.ApplyDelta(ctx, Delta[K, V]{Upserts: data})
+}
+
+// ApplyDelta применяет Delta к Cache согласно выбранной стратегии.
+// Delta.Deleted учитывается только стратегией StrategyIncremental.
+func (s *CacheSink[K, V]) ApplyDelta(ctx context.Context, delta Delta[K, V]) error {
+	switch s.strategy {
+	case StrategyReplace:
+		// Полная замена: хранилище кэша подменяется целиком под одной блокировкой,
+		// читатели видят либо старые, либо новые данные, но не их смесь
+		s.cache.replaceAll(delta.Upserts, s.ttl)
+
+	case StrategyMerge, StrategyUpsertOnly:
+		// Merge/Upsert: обновляем существующие и добавляем новые
+		for k, v := range delta.Upserts {
+			s.cache.SetWithTTL(k, v, s.ttl)
+		}
+
+	case StrategyIncremental:
+		// Инкрементальное обновление: сначала удаления, затем вставки
+		for _, k := range delta.Deleted {
+			s.cache.Delete(k)
+		}
+		for k, v := range delta.Upserts {
+			s.cache.SetWithTTL(k, v, s.ttl)
+		}
+	}
+
+	return nil
+}
 
 // FetchFunc - сигнатура функции получения данных.
 type FetchFunc[T any] func(ctx context.Context) (T, error)
@@ -106,17 +280,31 @@ type Updater[K comparable, V any] struct {
 	sink     Sink[K, V]
 	interval time.Duration
 
-	middlewares []Middleware[FetchFunc[map[K]V]]
+	// Источник и приемник Delta, задаются через NewDeltaUpdater вместо source и sink
+	deltaSource Source[Delta[K, V]]
+	deltaSink   DeltaSink[K, V]
+
+	middlewares      []Middleware[FetchFunc[map[K]V]]
+	deltaMiddlewares []Middleware[FetchFunc[Delta[K, V]]]
 
 	// Колбэки для мониторинга и реакции на события
 	onSuccess func(data map[K]V)
 	onError   func(error)
+	onChange  func(changes Changes[K, V])
 
 	// Управление жизненным циклом
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
+	// Внеочередные обновления, см. Refresh и Trigger
+	trigger    chan struct{}
+	inflightMu sync.Mutex
+	inflight   *updateRun
+
+	// Источник времени для тикера и метаданных
+	clock Clock
+
 	// Метаданные (опционально для мониторинга)
 	mu          sync.RWMutex
 	lastSuccess time.Time
@@ -134,22 +322,67 @@ func NewUpdater[K comparable, V any](
 		sink:        sink,
 		interval:    interval,
 		middlewares: make([]Middleware[FetchFunc[map[K]V]], 0),
+		trigger:     make(chan struct{}, 1),
+		clock:       RealClock{},
 	}
 }
 
+// NewDeltaUpdater создает Updater, который получает из источника Delta
+// и применяет ее через DeltaSink, так что удаления доходят до хранилища.
+// Для middleware используется WithDeltaMiddleware, обработчик успеха получает Delta.Upserts.
+func NewDeltaUpdater[K comparable, V any](
+	source Source[Delta[K, V]],
+	sink DeltaSink[K, V],
+	interval time.Duration,
+) *Updater[K, V] {
+	return &Updater[K, V]{
+		deltaSource:      source,
+		deltaSink:        sink,
+		interval:         interval,
+		deltaMiddlewares: make([]Middleware[FetchFunc[Delta[K, V]]], 0),
+		trigger:          make(chan struct{}, 1),
+		clock:            RealClock{},
+	}
+}
+
+// WithClock устанавливает источник времени (по умолчанию RealClock).
+// В тестах позволяет управлять тикером через FakeClock без реальных пауз.
+func (u *Updater[K, V]) WithClock(clock Clock) *Updater[K, V] {
+	u.clock = clock
+	return u
+}
+
 // WithMiddleware добавляет middleware в цепочку обработки.
+// Middleware оборачивает Fetch дополнительной логикой,
+// например Retry, CircuitBreakerMiddleware, logging, validation, caching.
 // Middleware выполняются в порядке добавления.
 func (u *Updater[K, V]) WithMiddleware(mw ...Middleware[FetchFunc[map[K]V]]) *Updater[K, V] {
 	u.middlewares = append(u.middlewares, mw...)
 	return u
 }
 
+// WithDeltaMiddleware добавляет middleware в цепочку обработки Updater, созданного NewDeltaUpdater.
+// Middleware выполняются в порядке добавления.
+func (u *Updater[K, V]) WithDeltaMiddleware(mw ...Middleware[FetchFunc[Delta[K, V]]]) *Updater[K, V] {
+	u.deltaMiddlewares = append(u.deltaMiddlewares, mw...)
+	return u
+}
+
 // WithSuccessHandler устанавливает обработчик успешных обновлений.
 func (u *Updater[K, V]) WithSuccessHandler(handler func(data map[K]V)) *Updater[K, V] {
 	u.onSuccess = handler
 	return u
 }
 
+// WithChangeHandler устанавливает обработчик изменений хранилища после каждого успешного обновления,
+// например для инвалидации зависимых кэшей.
+// Вызывается, только если приемник реализует ChangeSink (например, StoreSink),
+// в том числе когда обновление ничего не изменило (см. Changes.Empty).
+func (u *Updater[K, V]) WithChangeHandler(handler func(changes Changes[K, V])) *Updater[K, V] {
+	u.onChange = handler
+	return u
+}
+
 // WithErrorHandler устанавливает обработчик ошибок.
 func (u *Updater[K, V]) WithErrorHandler(handler func(error)) *Updater[K, V] {
 	u.onError = handler
@@ -160,7 +393,9 @@ func (u *Updater[K, V]) WithErrorHandler(handler func(error)) *Updater[K, V] {
 // Возвращает управление немедленно, обновления происходят в горутине.
 // Первое обновление произойдет после истечения interval.
 func (u *Updater[K, V]) Start(ctx context.Context) {
+	u.inflightMu.Lock()
 	u.ctx, u.cancel = context.WithCancel(ctx)
+	u.inflightMu.Unlock()
 	u.wg.Add(1)
 
 	go u.run()
@@ -171,10 +406,13 @@ func (u *Updater[K, V]) Start(ctx context.Context) {
 // Возвращает ошибку если первое обновление не удалось.
 // После успешного первого обновления работает как Start().
 func (u *Updater[K, V]) StartSync(ctx context.Context) error {
+	u.inflightMu.Lock()
 	u.ctx, u.cancel = context.WithCancel(ctx)
+	u.inflightMu.Unlock()
 
-	// Выполняем первое обновление синхронно
-	if err := u.updateOnce(u.ctx); err != nil {
+	// Выполняем первое обновление синхронно,
+	// присоединяясь к уже выполняющемуся (например, вызванному Refresh)
+	if err := u.waitUpdate(context.Background()); err != nil {
 		u.cancel()
 		return err
 	}
@@ -194,35 +432,121 @@ func (u *Updater[K, V]) Stop() {
 	u.wg.Wait()
 }
 
+// Refresh выполняет обновление немедленно и дожидается его результата.
+// Если обновление уже выполняется (по тикеру или другим вызовом Refresh),
+// Refresh присоединяется к нему вместо запуска нового.
+// При отмене ctx возвращает ctx.Err(); само обновление прерывается,
+// только если его больше никто не ждет.
+// Запущенное Refresh обновление прерывается и вызовом Stop.
+// Не меняет расписание тикера, в отличие от Trigger.
+func (u *Updater[K, V]) Refresh(ctx context.Context) error {
+	return u.waitUpdate(ctx)
+}
+
+// Trigger запрашивает внеочередное обновление и возвращает управление немедленно.
+// Фоновая горутина выполняет обновление и отсчитывает следующее срабатывание тикера
+// заново от текущего момента. Повторные вызовы до начала обновления схлопываются в один.
+// До вызова Start запрос откладывается до запуска.
+func (u *Updater[K, V]) Trigger() {
+	select {
+	case u.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// updateRun - выполняющееся обновление, общее для всех, кто его ждет.
+type updateRun struct {
+	waiter  ResultWaiterFn[struct{}]
+	cancel  context.CancelFunc
+	waiters int
+}
+
+// waitUpdate запускает обновление или присоединяется к уже выполняющемуся и дожидается его результата.
+// Пока Updater запущен, обновление выполняется с контекстом u.ctx и прерывается вызовом Stop,
+// иначе - со значениями ctx, но без его отмены.
+// При отмене ctx возвращает ctx.Err(); если это был последний ожидающий, обновление прерывается.
+func (u *Updater[K, V]) waitUpdate(ctx context.Context) error {
+	u.inflightMu.Lock()
+	run := u.inflight
+	if run == nil {
+		parent := context.WithoutCancel(ctx)
+		if u.ctx != nil && u.ctx.Err() == nil {
+			parent = u.ctx
+		}
+		runCtx, cancel := context.WithCancel(parent)
+		run = &updateRun{cancel: cancel}
+		run.waiter = WaitResult(func() (struct{}, error) {
+			defer cancel()
+			// Вызовы после завершения обновления запускают новое
+			defer u.forgetUpdate(run)
+			return struct{}{}, u.updateOnce(runCtx)
+		})
+		u.inflight = run
+	}
+	run.waiters++
+	u.inflightMu.Unlock()
+
+	res, ok := run.waiter(ctx)
+
+	u.inflightMu.Lock()
+	run.waiters--
+	if !ok && run.waiters == 0 {
+		// Обновление больше никто не ждет: прерываем его, следующий вызов запустит новое
+		run.cancel()
+		u.forgetUpdateLocked(run)
+	}
+	u.inflightMu.Unlock()
+
+	if !ok {
+		return ctx.Err()
+	}
+	return res.Error
+}
+
+// forgetUpdate сбрасывает u.inflight, если там все еще run.
+func (u *Updater[K, V]) forgetUpdate(run *updateRun) {
+	u.inflightMu.Lock()
+	defer u.inflightMu.Unlock()
+	u.forgetUpdateLocked(run)
+}
+
+// forgetUpdateLocked - forgetUpdate для вызывающего, который держит u.inflightMu.
+func (u *Updater[K, V]) forgetUpdateLocked(run *updateRun) {
+	if u.inflight == run {
+		u.inflight = nil
+	}
+}
+
 // run - основной цикл обновления.
 func (u *Updater[K, V]) run() {
 	defer u.wg.Done()
 
-	ticker := time.NewTicker(u.interval)
+	ticker := u.clock.NewTicker(u.interval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-u.ctx.Done():
 			return
-		case <-ticker.C:
+		case <-ticker.C():
+			u.update()
+		case <-u.trigger:
+			ticker.Reset(u.interval)
 			u.update()
 		}
 	}
 }
 
 // update выполняет одну итерацию обновления (без возврата ошибки).
+// Ждет ее завершения даже после отмены u.ctx, чтобы Stop дожидался текущей итерации.
 func (u *Updater[K, V]) update() {
-	_ = u.updateOnce(u.ctx)
+	_ = u.waitUpdate(context.Background())
 }
 
 // updateOnce выполняет одну итерацию обновления и возвращает ошибку.
 func (u *Updater[K, V]) updateOnce(ctx context.Context) error {
-	// Строим цепочку middleware
-	fetchFunc := u.buildFetchChain()
-
 	// Выполняем fetch через middleware chain
-	data, err := fetchFunc(ctx)
+	delta, err := u.fetch(ctx)
 	if err != nil {
 		u.setLastError(err)
 		if u.onError != nil {
@@ -232,7 +556,8 @@ func (u *Updater[K, V]) updateOnce(ctx context.Context) error {
 	}
 
 	// Применяем данные к sink
-	if err := u.sink.Apply(ctx, data); err != nil {
+	changes, tracked, err := u.apply(ctx, delta)
+	if err != nil {
 		u.setLastError(err)
 		if u.onError != nil {
 			u.onError(err)
@@ -243,21 +568,62 @@ func (u *Updater[K, V]) updateOnce(ctx context.Context) error {
 	// Успешное обновление
 	u.setLastSuccess()
 	if u.onSuccess != nil {
-		u.onSuccess(data)
+		u.onSuccess(delta.Upserts)
+	}
+	if tracked {
+		u.onChange(changes)
 	}
 
 	return nil
 }
 
+// fetch получает данные из источника через цепочку middleware.
+// Данные обычного источника представляются как Delta без удалений.
+func (u *Updater[K, V]) fetch(ctx context.Context) (Delta[K, V], error) {
+	if u.deltaSource != nil {
+		return chainFetch(u.deltaSource.Fetch, u.deltaMiddlewares)(ctx)
+	}
+
+	data, err := u.buildFetchChain()(ctx)
+	return Delta[K, V]{Upserts: data}, err
+}
+
+// apply применяет Delta к приемнику: DeltaSink получает ее целиком,
+// обычный Sink - только Delta.Upserts.
+// Если задан обработчик изменений и приемник реализует ChangeSink,
+// возвращает изменения и tracked = true.
+func (u *Updater[K, V]) apply(ctx context.Context, delta Delta[K, V]) (changes Changes[K, V], tracked bool, err error) {
+	var sink any = u.sink
+	if u.deltaSink != nil {
+		sink = u.deltaSink
+	} else {
+		// Обычный Sink не получает удалений
+		delta.Deleted = nil
+	}
+
+	if changeSink, ok := sink.(ChangeSink[K, V]); ok && u.onChange != nil {
+		changes, err = changeSink.ApplyChanges(ctx, delta)
+		return changes, err == nil, err
+	}
+
+	if u.deltaSink != nil {
+		return changes, false, u.deltaSink.ApplyDelta(ctx, delta)
+	}
+	return changes, false, u.sink.Apply(ctx, delta.Upserts)
+}
+
 // buildFetchChain строит цепочку middleware для fetch.
 func (u *Updater[K, V]) buildFetchChain() FetchFunc[map[K]V] {
 	// Базовая функция - вызов source.Fetch
-	fetchFunc := u.source.Fetch
+	return chainFetch(u.source.Fetch, u.middlewares)
+}
 
+// chainFetch оборачивает fetchFunc в middleware.
+func chainFetch[T any](fetchFunc FetchFunc[T], middlewares []Middleware[FetchFunc[T]]) FetchFunc[T] {
 	// Оборачиваем в middleware в обратном порядке
 	// (чтобы первый добавленный middleware был внешним)
-	for i := len(u.middlewares) - 1; i >= 0; i-- {
-		fetchFunc = u.middlewares[i](fetchFunc)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		fetchFunc = middlewares[i](fetchFunc)
 	}
 
 	return fetchFunc
@@ -280,7 +646,7 @@ func (u *Updater[K, V]) LastError() error {
 func (u *Updater[K, V]) setLastSuccess() {
 	u.mu.Lock()
 	defer u.mu.Unlock()
-	u.lastSuccess = time.Now()
+	u.lastSuccess = u.clock.Now()
 	u.lastError = nil
 }
 
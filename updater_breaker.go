@@ -0,0 +1,220 @@
+package notstd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen возвращается вместо вызова fetch, пока CircuitBreaker разомкнут.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState - состояние CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed - вызовы проходят, подряд идущие ошибки подсчитываются.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen - вызовы сразу завершаются ErrCircuitOpen до истечения cool-down.
+	CircuitOpen
+	// CircuitHalfOpen - после cool-down пропускается по одному пробному вызову,
+	// успехи замыкают цепь, ошибка снова ее размыкает.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker прекращает обращения к недоступному источнику после серии ошибок
+// и периодически проверяет, не восстановился ли он. Подключается через CircuitBreakerMiddleware.
+// Один CircuitBreaker можно использовать в нескольких middleware, чтобы они делили состояние.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	successes int
+	openedAt  time.Time
+	probing   bool
+	// generation меняется при каждой смене состояния, чтобы результаты вызовов,
+	// начатых в прежнем состоянии, не влияли на новое
+	generation uint64
+
+	failureThreshold int
+	successThreshold int
+	coolDown         time.Duration
+	isFailure        FilterFn[error]
+	clock            Clock
+	onStateChange    func(from, to CircuitState)
+}
+
+// NewCircuitBreaker создает новый CircuitBreaker.
+// failureThreshold - число ошибок подряд, после которого цепь размыкается (минимум 1).
+// coolDown - сколько цепь остается разомкнутой до пробного вызова.
+func NewCircuitBreaker(failureThreshold int, coolDown time.Duration) *CircuitBreaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		successThreshold: 1,
+		coolDown:         coolDown,
+		isFailure: func(err error) bool {
+			return !errors.Is(err, context.Canceled)
+		},
+		clock: RealClock{},
+	}
+}
+
+// WithSuccessThreshold устанавливает число успешных пробных вызовов подряд,
+// после которого цепь снова замыкается (по умолчанию 1).
+func (cb *CircuitBreaker) WithSuccessThreshold(successThreshold int) *CircuitBreaker {
+	if successThreshold < 1 {
+		successThreshold = 1
+	}
+	cb.successThreshold = successThreshold
+	return cb
+}
+
+// WithFailureFilter определяет, какие ошибки считаются отказом источника
+// (по умолчанию все, кроме context.Canceled). Остальные ошибки не влияют на состояние.
+func (cb *CircuitBreaker) WithFailureFilter(isFailure FilterFn[error]) *CircuitBreaker {
+	cb.isFailure = isFailure
+	return cb
+}
+
+// WithClock устанавливает источник времени для cool-down (по умолчанию RealClock).
+func (cb *CircuitBreaker) WithClock(clock Clock) *CircuitBreaker {
+	cb.clock = clock
+	return cb
+}
+
+// WithStateChangeHandler устанавливает обработчик смены состояния, например для логов и метрик.
+// Вызывается синхронно, после освобождения внутренней блокировки.
+func (cb *CircuitBreaker) WithStateChangeHandler(handler func(from, to CircuitState)) *CircuitBreaker {
+	cb.onStateChange = handler
+	return cb
+}
+
+// State возвращает текущее состояние.
+// Разомкнутая цепь, у которой истек cool-down, сообщается как CircuitHalfOpen.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	from := cb.state
+	to := cb.halfOpenLocked()
+	cb.mu.Unlock()
+
+	cb.changed(from, to)
+	return to
+}
+
+// allow решает, можно ли выполнить вызов, и резервирует пробный вызов в CircuitHalfOpen.
+// Возвращает поколение, которое нужно передать в record.
+func (cb *CircuitBreaker) allow() (uint64, error) {
+	cb.mu.Lock()
+	from := cb.state
+	to := cb.halfOpenLocked()
+	generation := cb.generation
+	var err error
+	switch {
+	case to == CircuitOpen:
+		err = ErrCircuitOpen
+	case to == CircuitHalfOpen && cb.probing:
+		// Пока идет пробный вызов, остальные не пропускаются
+		err = ErrCircuitOpen
+	case to == CircuitHalfOpen:
+		cb.probing = true
+	}
+	cb.mu.Unlock()
+
+	cb.changed(from, to)
+	return generation, err
+}
+
+// record учитывает результат вызова, разрешенного allow.
+// Результаты вызовов, начатых до смены состояния, игнорируются.
+func (cb *CircuitBreaker) record(generation uint64, err error) {
+	cb.mu.Lock()
+	if generation != cb.generation {
+		cb.mu.Unlock()
+		return
+	}
+	from := cb.state
+	if from == CircuitHalfOpen {
+		cb.probing = false
+	}
+
+	switch {
+	case err == nil:
+		cb.failures = 0
+		if from == CircuitHalfOpen {
+			cb.successes++
+			if cb.successes >= cb.successThreshold {
+				cb.setStateLocked(CircuitClosed)
+			}
+		}
+	case cb.isFailure(err):
+		cb.failures++
+		if from == CircuitHalfOpen || cb.failures >= cb.failureThreshold {
+			cb.setStateLocked(CircuitOpen)
+		}
+	}
+	to := cb.state
+	cb.mu.Unlock()
+
+	cb.changed(from, to)
+}
+
+// halfOpenLocked переводит разомкнутую цепь в CircuitHalfOpen по истечении cool-down
+// и возвращает текущее состояние.
+func (cb *CircuitBreaker) halfOpenLocked() CircuitState {
+	if cb.state == CircuitOpen && !cb.clock.Now().Before(cb.openedAt.Add(cb.coolDown)) {
+		cb.setStateLocked(CircuitHalfOpen)
+	}
+	return cb.state
+}
+
+func (cb *CircuitBreaker) setStateLocked(state CircuitState) {
+	cb.state = state
+	cb.failures = 0
+	cb.successes = 0
+	cb.probing = false
+	cb.generation++
+	if state == CircuitOpen {
+		cb.openedAt = cb.clock.Now()
+	}
+}
+
+func (cb *CircuitBreaker) changed(from, to CircuitState) {
+	if from != to && cb.onStateChange != nil {
+		cb.onStateChange(from, to)
+	}
+}
+
+// CircuitBreakerMiddleware создает middleware, пропускающий fetch через cb:
+// пока цепь разомкнута, fetch не вызывается и возвращается ErrCircuitOpen.
+func CircuitBreakerMiddleware[T any](cb *CircuitBreaker) Middleware[FetchFunc[T]] {
+	return func(next FetchFunc[T]) FetchFunc[T] {
+		return func(ctx context.Context) (T, error) {
+			generation, err := cb.allow()
+			if err != nil {
+				var zero T
+				return zero, err
+			}
+
+			data, err := next(ctx)
+			cb.record(generation, err)
+			return data, err
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package notstd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryConfig - параметры повторных попыток для Retry.
+type RetryConfig struct {
+	// MaxAttempts - максимальное число попыток, включая первую (0 - без ограничения).
+	MaxAttempts int
+	// MaxElapsed - максимальное время от начала первой попытки (0 - без ограничения).
+	// Попытка, которая началась бы позже, не выполняется.
+	MaxElapsed time.Duration
+
+	// InitialDelay - верхняя граница первой паузы (по умолчанию 100ms).
+	InitialDelay time.Duration
+	// MaxDelay - верхняя граница любой паузы (0 - без ограничения).
+	MaxDelay time.Duration
+	// Multiplier - во сколько раз растет граница паузы после каждой попытки (по умолчанию 2).
+	Multiplier float64
+
+	// Retryable определяет, стоит ли повторять попытку после ошибки
+	// (nil - повторять любые ошибки, кроме ошибок контекста).
+	Retryable FilterFn[error]
+	// Clock - источник времени для пауз (nil - RealClock).
+	Clock Clock
+}
+
+// Retry создает middleware, повторяющий неудачный fetch с экспоненциальной паузой и full jitter:
+// пауза перед n-й повторной попыткой выбирается случайно в [0, min(MaxDelay, InitialDelay*Multiplier^(n-1))).
+// Без MaxAttempts и MaxElapsed попытки продолжаются, пока не отменен ctx.
+// Возвращает ошибку последней попытки, либо ctx.Err(), если ctx отменен во время паузы.
+func Retry[T any](cfg RetryConfig) Middleware[FetchFunc[T]] {
+	if cfg.InitialDelay <= 0 {
+		cfg.InitialDelay = 100 * time.Millisecond
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = 2
+	}
+	if cfg.Retryable == nil {
+		cfg.Retryable = func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = RealClock{}
+	}
+
+	return func(next FetchFunc[T]) FetchFunc[T] {
+		return func(ctx context.Context) (T, error) {
+			started := cfg.Clock.Now()
+			ceiling := cfg.InitialDelay
+
+			for attempt := 1; ; attempt++ {
+				data, err := next(ctx)
+				if err == nil || !cfg.Retryable(err) {
+					return data, err
+				}
+				if cfg.MaxAttempts > 0 && attempt >= cfg.MaxAttempts {
+					return data, err
+				}
+
+				if cfg.MaxDelay > 0 && ceiling > cfg.MaxDelay {
+					ceiling = cfg.MaxDelay
+				}
+				delay := time.Duration(rand.Int63n(int64(ceiling)))
+				if cfg.MaxElapsed > 0 && cfg.Clock.Now().Add(delay).Sub(started) > cfg.MaxElapsed {
+					return data, err
+				}
+
+				select {
+				case <-ctx.Done():
+					var zero T
+					return zero, ctx.Err()
+				case <-cfg.Clock.After(delay):
+				}
+
+				if grown := float64(ceiling) * cfg.Multiplier; grown < math.MaxInt64 {
+					ceiling = time.Duration(grown)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,732 @@
+package notstd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpdaterClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+
+	var fetches int32
+	source := FetchFunc[map[string]int](func(ctx context.Context) (map[string]int, error) {
+		n := atomic.AddInt32(&fetches, 1)
+		return map[string]int{"n": int(n)}, nil
+	})
+	store := NewStore[string, int](nil)
+
+	updated := make(chan struct{}, 1)
+	u := NewUpdater[string, int](source, NewStoreSink(store, StrategyReplace), time.Hour).
+		WithClock(clock).
+		WithSuccessHandler(func(map[string]int) { updated <- struct{}{} })
+
+	if err := u.StartSync(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer u.Stop()
+	<-updated
+
+	if !u.LastSuccess().Equal(clock.Now()) {
+		t.Errorf("expected LastSuccess from the fake clock, got %v", u.LastSuccess())
+	}
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("expected an update after advancing the clock")
+	}
+
+	if v, _ := store.Get("n"); v != 2 {
+		t.Errorf("expected 2 fetches, got %d", v)
+	}
+}
+
+func TestCacheSink(t *testing.T) {
+	t.Run("replace", func(t *testing.T) {
+		var mu sync.Mutex
+		evicted := make(map[string]EvictReason)
+		cache := NewCache[string, int](0, 0, nil, nil).
+			WithEvictHandler(func(key string, value int, reason EvictReason) {
+				mu.Lock()
+				evicted[key] = reason
+				mu.Unlock()
+			})
+		sink := NewCacheSink(cache, StrategyReplace, time.Minute)
+
+		cache.Set("stale", 1)
+		cache.Set("kept", 1)
+		if err := sink.Apply(context.Background(), map[string]int{"kept": 2, "new": 3}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if cache.Has("stale") {
+			t.Error("expected stale to be removed")
+		}
+		if v, _ := cache.GetNoDefault("kept"); v != 2 {
+			t.Errorf("expected kept to be 2, got %d", v)
+		}
+		if v, _ := cache.GetNoDefault("new"); v != 3 {
+			t.Errorf("expected new to be 3, got %d", v)
+		}
+		if evicted["stale"] != EvictDeleted || evicted["kept"] != EvictReplaced || len(evicted) != 2 {
+			t.Errorf("unexpected evictions %v", evicted)
+		}
+	})
+
+	t.Run("replace beyond capacity", func(t *testing.T) {
+		cache := NewCache[string, int](0, 0, nil, nil).WithMaxEntries(2)
+		sink := NewCacheSink(cache, StrategyReplace, CacheDefaultTTL)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := cache.Subscribe(ctx)
+
+		cache.Set("old", 0)
+		sink.Apply(context.Background(), map[string]int{"a": 1, "b": 2, "c": 3})
+
+		// Replaying the events must yield the actual contents of the cache
+		present := make(Set[string])
+		for done := false; !done; {
+			select {
+			case e := <-events:
+				if e.Type == CacheEventSet {
+					present.Add(e.Key)
+				} else {
+					present.Delete(e.Key)
+				}
+			case <-time.After(50 * time.Millisecond):
+				done = true
+			}
+		}
+
+		keys := cache.Keys()
+		if len(keys) != 2 || present.Len() != len(keys) {
+			t.Fatalf("expected events to describe %v, got %v", keys, present)
+		}
+		for _, k := range keys {
+			if !present.Contains(k) {
+				t.Errorf("expected %s to be present according to events", k)
+			}
+		}
+	})
+
+	t.Run("replace is atomic for readers", func(t *testing.T) {
+		cache := NewCache[int, int](0, 0, nil, nil)
+		sink := NewCacheSink(cache, StrategyReplace, CacheDefaultTTL)
+
+		generation := func(g int) map[int]int {
+			data := make(map[int]int, 100)
+			for k := 0; k < 100; k++ {
+				data[k] = g
+			}
+			return data
+		}
+		sink.Apply(context.Background(), generation(0))
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				seen := make(Set[int])
+				cache.Range(func(key, value int) bool {
+					seen.Add(value)
+					return true
+				})
+				if seen.Len() > 1 {
+					t.Errorf("observed a half-applied refresh: %v", seen)
+					return
+				}
+			}
+		}()
+
+		for g := 1; g <= 50; g++ {
+			sink.Apply(context.Background(), generation(g))
+		}
+		close(done)
+		wg.Wait()
+	})
+
+	t.Run("merge with ttl", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		cache := NewCache[string, int](0, 0, nil, nil).WithClock(clock)
+		sink := NewCacheSink(cache, StrategyMerge, time.Minute)
+
+		cache.Set("other", 1)
+		sink.Apply(context.Background(), map[string]int{"key": 1})
+		if !cache.Has("other") || !cache.Has("key") {
+			t.Error("expected merge to keep existing keys")
+		}
+
+		clock.Advance(2 * time.Minute)
+		if cache.Has("key") {
+			t.Error("expected key to expire after the sink ttl")
+		}
+		if !cache.Has("other") {
+			t.Error("expected other to remain")
+		}
+	})
+}
+
+func TestStoreSinkDelta(t *testing.T) {
+	store := NewStore(map[string]int{"a": 1, "b": 2, "c": 0})
+	sink := NewStoreSink(store, StrategyIncremental)
+
+	err := sink.ApplyDelta(context.Background(), Delta[string, int]{
+		Upserts: map[string]int{"a": 10, "d": 0},
+		Deleted: []string{"b", "c"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := map[string]int{"a": 10, "d": 0}
+	got := store.GetMap()
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("expected %s=%d, got %d", k, v, got[k])
+		}
+	}
+
+	merge := NewStoreSink(store, StrategyMerge)
+	merge.ApplyDelta(context.Background(), Delta[string, int]{Deleted: []string{"a"}})
+	if _, ok := store.Get("a"); !ok {
+		t.Error("expected StrategyMerge to ignore deletions")
+	}
+}
+
+func TestDeltaUpdater(t *testing.T) {
+	deltas := []Delta[string, int]{
+		{Upserts: map[string]int{"a": 1, "b": 2}},
+		{Upserts: map[string]int{"c": 3}, Deleted: []string{"a"}},
+	}
+	var calls atomic.Int32
+	source := FetchFunc[Delta[string, int]](func(ctx context.Context) (Delta[string, int], error) {
+		return deltas[calls.Add(1)-1], nil
+	})
+
+	var wrapped atomic.Int32
+	count := func(next FetchFunc[Delta[string, int]]) FetchFunc[Delta[string, int]] {
+		return func(ctx context.Context) (Delta[string, int], error) {
+			wrapped.Add(1)
+			return next(ctx)
+		}
+	}
+
+	cache := NewCache[string, int](0, 0, nil, nil)
+	u := NewDeltaUpdater[string, int](source, NewCacheSink(cache, StrategyIncremental, CacheDefaultTTL), time.Hour).
+		WithDeltaMiddleware(count)
+
+	for range deltas {
+		if err := u.updateOnce(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if cache.Has("a") || !cache.Has("b") || !cache.Has("c") {
+		t.Errorf("expected keys b and c, got %v", cache.Keys())
+	}
+	if wrapped.Load() != 2 {
+		t.Errorf("expected middleware to wrap 2 fetches, got %d", wrapped.Load())
+	}
+}
+
+func TestStoreSinkChanges(t *testing.T) {
+	t.Run("replace", func(t *testing.T) {
+		store := NewStore(map[string]int{"same": 1, "changed": 1, "removed": 1})
+		sink := NewStoreSink(store, StrategyReplace)
+
+		changes, err := sink.ApplyChanges(context.Background(), Delta[string, int]{
+			Upserts: map[string]int{"same": 1, "changed": 2, "added": 3},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(changes.Added) != 1 || changes.Added["added"] != 3 {
+			t.Errorf("unexpected added %v", changes.Added)
+		}
+		if len(changes.Updated) != 1 || changes.Updated["changed"] != (ValueChange[int]{Old: 1, New: 2}) {
+			t.Errorf("unexpected updated %v", changes.Updated)
+		}
+		if len(changes.Removed) != 1 || changes.Removed["removed"] != 1 {
+			t.Errorf("unexpected removed %v", changes.Removed)
+		}
+	})
+
+	t.Run("incremental with custom equal", func(t *testing.T) {
+		store := NewStore(map[string]string{"a": "x", "b": "y"})
+		sink := NewStoreSink(store, StrategyIncremental).WithEqualFn(strings.EqualFold)
+
+		changes, _ := sink.ApplyChanges(context.Background(), Delta[string, string]{
+			Upserts: map[string]string{"a": "X"},
+			Deleted: []string{"b", "missing"},
+		})
+		if len(changes.Updated) != 0 {
+			t.Errorf("expected case-insensitive equal values to be unchanged, got %v", changes.Updated)
+		}
+		if len(changes.Removed) != 1 || changes.Removed["b"] != "y" {
+			t.Errorf("unexpected removed %v", changes.Removed)
+		}
+		if !(Changes[string, string]{}).Empty() || changes.Empty() {
+			t.Error("unexpected Empty result")
+		}
+	})
+}
+
+func TestUpdaterChangeHandler(t *testing.T) {
+	data := []map[string]int{{"a": 1}, {"a": 1}, {"a": 2}}
+	var calls atomic.Int32
+	source := FetchFunc[map[string]int](func(ctx context.Context) (map[string]int, error) {
+		return data[calls.Add(1)-1], nil
+	})
+
+	var changes []Changes[string, int]
+	u := NewUpdater[string, int](source, NewStoreSink(NewStore[string, int](nil), StrategyReplace), time.Hour).
+		WithChangeHandler(func(c Changes[string, int]) { changes = append(changes, c) })
+
+	for range data {
+		if err := u.updateOnce(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 change reports, got %d", len(changes))
+	}
+	if changes[0].Added["a"] != 1 || !changes[1].Empty() || changes[2].Updated["a"].New != 2 {
+		t.Errorf("unexpected changes %+v", changes)
+	}
+}
+
+func TestUpdaterRefresh(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	source := FetchFunc[map[string]int](func(ctx context.Context) (map[string]int, error) {
+		n := fetches.Add(1)
+		<-release
+		return map[string]int{"n": int(n)}, nil
+	})
+	store := NewStore[string, int](nil)
+	u := NewUpdater[string, int](source, NewStoreSink(store, StrategyReplace), time.Hour)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- u.Refresh(context.Background())
+		}()
+	}
+
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A caller that gives up does not cancel the run while others wait for it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := u.Refresh(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	if fetches.Load() != 1 {
+		t.Errorf("expected concurrent refreshes to share 1 fetch, got %d", fetches.Load())
+	}
+	if v, _ := store.Get("n"); v != 1 {
+		t.Errorf("expected store to be updated, got %d", v)
+	}
+
+	if err := u.Refresh(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if fetches.Load() != 2 {
+		t.Errorf("expected a new fetch after the previous one finished, got %d", fetches.Load())
+	}
+}
+
+func TestUpdaterStartSyncRefresh(t *testing.T) {
+	var fetches atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	source := FetchFunc[map[string]int](func(ctx context.Context) (map[string]int, error) {
+		if fetches.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return map[string]int{}, nil
+	})
+	u := NewUpdater[string, int](source, NewStoreSink(NewStore[string, int](nil), StrategyReplace), time.Hour)
+
+	syncErr := make(chan error, 1)
+	go func() {
+		syncErr <- u.StartSync(context.Background())
+	}()
+	<-started
+
+	refreshErr := make(chan error, 1)
+	go func() {
+		refreshErr <- u.Refresh(context.Background())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	if err := <-syncErr; err != nil {
+		t.Fatalf("unexpected StartSync error: %v", err)
+	}
+	defer u.Stop()
+	if err := <-refreshErr; err != nil {
+		t.Errorf("unexpected Refresh error: %v", err)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("expected Refresh to join the initial update, got %d fetches", fetches.Load())
+	}
+}
+
+func TestUpdaterRefreshCancel(t *testing.T) {
+	newUpdater := func() (*Updater[string, int], <-chan struct{}) {
+		started := make(chan struct{})
+		source := FetchFunc[map[string]int](func(ctx context.Context) (map[string]int, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		return NewUpdater[string, int](source, NewStoreSink(NewStore[string, int](nil), StrategyReplace), time.Hour), started
+	}
+
+	t.Run("Stop cancels a Refresh-started fetch", func(t *testing.T) {
+		u, started := newUpdater()
+		u.Start(context.Background())
+
+		refreshErr := make(chan error, 1)
+		go func() {
+			refreshErr <- u.Refresh(context.Background())
+		}()
+		<-started
+
+		// The run loop joins the fetch, so Stop waits for it
+		u.Trigger()
+		for joined := false; !joined; time.Sleep(time.Millisecond) {
+			u.inflightMu.Lock()
+			joined = u.inflight != nil && u.inflight.waiters == 2
+			u.inflightMu.Unlock()
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			u.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("expected Stop to return while Refresh is fetching")
+		}
+		if err := <-refreshErr; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+
+	t.Run("last caller giving up cancels the fetch", func(t *testing.T) {
+		u, started := newUpdater()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		refreshErr := make(chan error, 1)
+		go func() {
+			refreshErr <- u.Refresh(ctx)
+		}()
+		<-started
+		cancel()
+
+		if err := <-refreshErr; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for !errors.Is(u.LastError(), context.Canceled) {
+			if time.Now().After(deadline) {
+				t.Fatal("expected the abandoned fetch to be cancelled")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestUpdaterTrigger(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	source := FetchFunc[map[string]int](func(ctx context.Context) (map[string]int, error) {
+		return map[string]int{}, nil
+	})
+
+	updated := make(chan struct{}, 1)
+	u := NewUpdater[string, int](source, NewStoreSink(NewStore[string, int](nil), StrategyReplace), time.Hour).
+		WithClock(clock).
+		WithSuccessHandler(func(map[string]int) { updated <- struct{}{} })
+
+	u.Start(context.Background())
+	defer u.Stop()
+	clock.BlockUntil(1)
+
+	clock.Advance(30 * time.Minute)
+	u.Trigger()
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("expected an update after Trigger")
+	}
+
+	// The schedule restarts from the trigger, so the original tick at 1h is skipped
+	clock.Advance(45 * time.Minute)
+	select {
+	case <-updated:
+		t.Fatal("expected no update before the rescheduled tick")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(15 * time.Minute)
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("expected an update at the rescheduled tick")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	failure := errors.New("unavailable")
+	failing := func(failures int32, calls *atomic.Int32) FetchFunc[int] {
+		return func(ctx context.Context) (int, error) {
+			if n := calls.Add(1); n <= failures {
+				return 0, failure
+			}
+			return 42, nil
+		}
+	}
+
+	t.Run("succeeds after retries", func(t *testing.T) {
+		var calls atomic.Int32
+		fetch := Retry[int](RetryConfig{MaxAttempts: 5, InitialDelay: time.Millisecond})(failing(2, &calls))
+
+		val, err := fetch(context.Background())
+		if err != nil || val != 42 {
+			t.Errorf("expected (42, nil), got (%d, %v)", val, err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("expected 3 attempts, got %d", calls.Load())
+		}
+	})
+
+	t.Run("stops after max attempts", func(t *testing.T) {
+		var calls atomic.Int32
+		fetch := Retry[int](RetryConfig{MaxAttempts: 3, InitialDelay: time.Millisecond})(failing(10, &calls))
+
+		if _, err := fetch(context.Background()); !errors.Is(err, failure) {
+			t.Errorf("expected last error, got %v", err)
+		}
+		if calls.Load() != 3 {
+			t.Errorf("expected 3 attempts, got %d", calls.Load())
+		}
+	})
+
+	t.Run("does not retry non-retryable errors", func(t *testing.T) {
+		var calls atomic.Int32
+		fetch := Retry[int](RetryConfig{
+			MaxAttempts:  5,
+			InitialDelay: time.Millisecond,
+			Retryable:    func(err error) bool { return !errors.Is(err, failure) },
+		})(failing(10, &calls))
+
+		if _, err := fetch(context.Background()); !errors.Is(err, failure) {
+			t.Errorf("expected error, got %v", err)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("expected 1 attempt, got %d", calls.Load())
+		}
+	})
+
+	t.Run("stops after max elapsed time", func(t *testing.T) {
+		var calls atomic.Int32
+		fetch := Retry[int](RetryConfig{
+			MaxElapsed:   50 * time.Millisecond,
+			InitialDelay: 10 * time.Millisecond,
+			Multiplier:   1,
+		})(failing(1<<30, &calls))
+
+		started := time.Now()
+		if _, err := fetch(context.Background()); !errors.Is(err, failure) {
+			t.Errorf("expected last error, got %v", err)
+		}
+		if elapsed := time.Since(started); elapsed > time.Second {
+			t.Errorf("expected retries to stop near 50ms, took %v", elapsed)
+		}
+		if calls.Load() < 2 {
+			t.Errorf("expected at least 2 attempts, got %d", calls.Load())
+		}
+	})
+
+	t.Run("respects context during backoff", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		var calls atomic.Int32
+		fetch := Retry[int](RetryConfig{InitialDelay: time.Hour, Clock: clock})(failing(10, &calls))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		errs := make(chan error, 1)
+		go func() {
+			_, err := fetch(ctx)
+			errs <- err
+		}()
+
+		clock.BlockUntil(1)
+		cancel()
+		select {
+		case err := <-errs:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected context.Canceled, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected fetch to return after cancellation")
+		}
+		if calls.Load() != 1 {
+			t.Errorf("expected 1 attempt, got %d", calls.Load())
+		}
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("unavailable")
+	clock := NewFakeClock(time.Now())
+
+	var transitions []string
+	cb := NewCircuitBreaker(3, time.Minute).
+		WithSuccessThreshold(2).
+		WithClock(clock).
+		WithStateChangeHandler(func(from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		})
+
+	var calls atomic.Int32
+	var fail atomic.Bool
+	fail.Store(true)
+	fetch := CircuitBreakerMiddleware[int](cb)(func(ctx context.Context) (int, error) {
+		calls.Add(1)
+		if fail.Load() {
+			return 0, failure
+		}
+		return 1, nil
+	})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := fetch(ctx); !errors.Is(err, failure) {
+			t.Fatalf("expected upstream error, got %v", err)
+		}
+	}
+	if cb.State() != CircuitOpen {
+		t.Fatalf("expected open circuit, got %v", cb.State())
+	}
+
+	if _, err := fetch(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected open circuit to short-circuit, got %d calls", calls.Load())
+	}
+
+	// A failed probe reopens the circuit for another cool-down
+	clock.Advance(time.Minute)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("expected half-open circuit, got %v", cb.State())
+	}
+	fetch(ctx)
+	if cb.State() != CircuitOpen {
+		t.Fatalf("expected failed probe to reopen the circuit, got %v", cb.State())
+	}
+
+	clock.Advance(time.Minute)
+	fail.Store(false)
+	for i := 0; i < 2; i++ {
+		if val, err := fetch(ctx); err != nil || val != 1 {
+			t.Fatalf("expected successful probe, got (%d, %v)", val, err)
+		}
+	}
+	if cb.State() != CircuitClosed {
+		t.Fatalf("expected closed circuit, got %v", cb.State())
+	}
+
+	want := []string{
+		"closed->open", "open->half-open", "half-open->open",
+		"open->half-open", "half-open->closed",
+	}
+	if strings.Join(transitions, ",") != strings.Join(want, ",") {
+		t.Errorf("expected transitions %v, got %v", want, transitions)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbe(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cb := NewCircuitBreaker(1, time.Minute).WithClock(clock)
+
+	failing := CircuitBreakerMiddleware[int](cb)(func(ctx context.Context) (int, error) {
+		return 0, errors.New("unavailable")
+	})
+	failing(context.Background())
+	clock.Advance(time.Minute)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	slow := CircuitBreakerMiddleware[int](cb)(func(ctx context.Context) (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		slow(context.Background())
+	}()
+	<-started
+
+	if _, err := failing(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected only one probe at a time, got %v", err)
+	}
+
+	close(release)
+	<-done
+	if cb.State() != CircuitClosed {
+		t.Errorf("expected closed circuit after successful probe, got %v", cb.State())
+	}
+
+	// Cancellation is not an upstream failure
+	canceled := CircuitBreakerMiddleware[int](cb)(func(ctx context.Context) (int, error) {
+		return 0, context.Canceled
+	})
+	canceled(context.Background())
+	if cb.State() != CircuitClosed {
+		t.Errorf("expected context.Canceled to be ignored, got %v", cb.State())
+	}
+}